@@ -8,8 +8,10 @@ require (
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/moul/http2curl v1.0.0 // indirect
 	github.com/ns3777k/go-shodan v3.1.0+incompatible
+	github.com/prometheus/client_golang v1.3.0
 	github.com/smartystreets/assertions v1.0.1 // indirect
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/vertoforce/genericenricher v0.0.0-20191212215538-58e52a02e760
 	github.com/vertoforce/multiregex v0.0.0-20191205214147-7cfc691a8511
+	gopkg.in/yaml.v3 v3.0.1
 )
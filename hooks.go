@@ -0,0 +1,58 @@
+package serverpatdown
+
+import (
+	"github.com/vertoforce/genericenricher"
+)
+
+// Hooks Called by the Searcher at each stage of processing, to add logging, metrics or alerting.  See AddHooks.
+// With more than one worker the hooks are called from several goroutines at once, so must be safe for concurrent use.
+// They are called in the path of the search and should return quickly
+type Hooks interface {
+	// OnServerStart Called before searching a server
+	OnServerStart(server genericenricher.Server)
+	// OnConnectError Called when connecting to a server fails
+	OnConnectError(server genericenricher.Server, err error)
+	// OnRuleHit Called when a rule matches data of a server.  Unless GetMatchedData is set this is only called for the
+	// first rule to match, and the RuleMatch only has the Rule.  Matches dropped because of MaxMatchesPerRule are skipped
+	OnRuleHit(server genericenricher.Server, match RuleMatch)
+	// OnServerDone Called once a server is searched, with the result whether or not it is returned by Process
+	OnServerDone(match *Match)
+	// OnReaderEOF Called when a ServerReader has no more servers
+	OnReaderEOF(reader ServerReader)
+	// OnReaderError Called when reading from a ServerReader fails, after which it is closed
+	OnReaderError(reader ServerReader, err error)
+}
+
+// NopHooks Hooks that do nothing.  Embed it to implement only some of Hooks
+type NopHooks struct{}
+
+// OnServerStart Does nothing
+func (NopHooks) OnServerStart(server genericenricher.Server) {}
+
+// OnConnectError Does nothing
+func (NopHooks) OnConnectError(server genericenricher.Server, err error) {}
+
+// OnRuleHit Does nothing
+func (NopHooks) OnRuleHit(server genericenricher.Server, match RuleMatch) {}
+
+// OnServerDone Does nothing
+func (NopHooks) OnServerDone(match *Match) {}
+
+// OnReaderEOF Does nothing
+func (NopHooks) OnReaderEOF(reader ServerReader) {}
+
+// OnReaderError Does nothing
+func (NopHooks) OnReaderError(reader ServerReader, err error) {}
+
+// AddHooks Add hooks to call while processing.  Hooks are called in the order they were added.
+// Add hooks before calling Process
+func (searcher *Searcher) AddHooks(hooks Hooks) {
+	searcher.hooks = append(searcher.hooks, hooks)
+}
+
+// callHooks Call each of the hooks
+func (searcher *Searcher) callHooks(call func(hooks Hooks)) {
+	for _, hooks := range searcher.hooks {
+		call(hooks)
+	}
+}
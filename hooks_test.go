@@ -0,0 +1,112 @@
+package serverpatdown
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vertoforce/genericenricher"
+)
+
+// recordingHooks Hooks that record each event
+type recordingHooks struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (h *recordingHooks) record(event string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.events = append(h.events, event)
+}
+
+func (h *recordingHooks) OnServerStart(server genericenricher.Server) {
+	h.record("start " + server.GetIP().String())
+}
+
+func (h *recordingHooks) OnConnectError(server genericenricher.Server, err error) {
+	h.record("connect error " + server.GetIP().String() + " " + err.Error())
+}
+
+func (h *recordingHooks) OnRuleHit(server genericenricher.Server, match RuleMatch) {
+	h.record("hit " + server.GetIP().String() + " " + match.Rule.ID + " " + string(match.Data))
+}
+
+func (h *recordingHooks) OnServerDone(match *Match) {
+	h.record("done " + match.Server.GetIP().String() + " " + match.Stage.String())
+}
+
+func (h *recordingHooks) OnReaderEOF(reader ServerReader) {
+	h.record("reader eof")
+}
+
+func (h *recordingHooks) OnReaderError(reader ServerReader, err error) {
+	h.record("reader error " + err.Error())
+}
+
+// doneHooks Hooks that only count servers done
+type doneHooks struct {
+	NopHooks
+	lock sync.Mutex
+	done int
+}
+
+func (h *doneHooks) OnServerDone(match *Match) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.done++
+}
+
+func TestHooks(t *testing.T) {
+	for _, getMatchedData := range []bool{false, true} {
+		searcher := NewSearcher()
+		searcher.Workers = 4
+		searcher.GetMatchedData = getMatchedData
+		searcher.AddRule(&Rule{ID: "secret", Regex: regexp.MustCompile(`secret`)})
+		searcher.AddScope(net.IPNet{IP: net.IP{127, 0, 0, 0}, Mask: net.CIDRMask(8, 32)})
+		searcher.AddServer(&fakeServer{ip: net.IP{127, 0, 0, 1}, data: []byte("a secret")})
+		searcher.AddServer(&fakeServer{ip: net.IP{127, 0, 0, 2}, connectErr: errors.New("refused")})
+		searcher.AddServer(&fakeServer{ip: net.IP{10, 0, 0, 1}, data: []byte("a secret")})
+		searcher.AddServerReader(&serverListReader{servers: []genericenricher.Server{
+			&fakeServer{ip: net.IP{127, 0, 0, 3}, data: []byte("nothing")},
+		}})
+		searcher.AddServerReader(&errorServerReader{err: errors.New("quota exhausted")})
+
+		hooks := &recordingHooks{}
+		counter := &doneHooks{}
+		searcher.AddHooks(hooks)
+		searcher.AddHooks(counter)
+
+		matches, err := searcher.Process(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range matches {
+		}
+
+		hit := "hit 127.0.0.1 secret "
+		if getMatchedData {
+			hit += "secret"
+		}
+		expected := []string{
+			"start 127.0.0.1", hit, "done 127.0.0.1 none",
+			"start 127.0.0.2", "connect error 127.0.0.2 refused", "done 127.0.0.2 connect",
+			"start 10.0.0.1", "done 10.0.0.1 scope",
+			"start 127.0.0.3", "done 127.0.0.3 none",
+			"reader eof", "reader error quota exhausted",
+		}
+		sort.Strings(expected)
+		sort.Strings(hooks.events)
+		if strings.Join(hooks.events, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Wrong events with GetMatchedData %v:\n%s", getMatchedData, strings.Join(hooks.events, "\n"))
+		}
+		if counter.done != 4 {
+			t.Errorf("Expected 4 servers done, got %d", counter.done)
+		}
+	}
+}
@@ -8,67 +8,213 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/vertoforce/genericenricher"
-	"github.com/vertoforce/multiregex"
+	"github.com/vertoforce/serverpatdown/serverreaders"
 )
 
 // IterationStyle How to iterate over the readers, breadth first or depth first
 type IterationStyle int
 
 const (
-	// BreadthFirst Iterate over each ServerReader reading a server round robin style
+	// BreadthFirst Iterate over each ServerReader reading a server round robin style.
+	// A reader with as many servers being processed as its concurrency limit is skipped for the round rather than
+	// holding up the others, so a slow limited reader falls behind and the servers are not strictly read in turn
 	BreadthFirst IterationStyle = iota
 	// DepthFirst Read all servers from a ServerReader before moving on to the next reader
 	DepthFirst
 
 	defaultServerTimeout = time.Second * 4
+	defaultWorkers       = 1
+
+	defaultCheckpointInterval = time.Second * 30
+	defaultProgressInterval   = time.Second * 5
 )
 
 // ServerReader Source of servers, should return EOF on each read after EOF
 // TODO: If an error is returned in the creation of the server, convention is you return a nil server and no error.
+//
+// ReadServer, Close and Reset must be safe to call from multiple goroutines, as a reader can be shared by
+// several searchers or wrapped by another reader.  Close may be called while a ReadServer is in progress,
+// which should then return soon unless it is waiting on a source that can't be interrupted such as os.Stdin,
+// and each read after Close returns EOF until Reset.
+// The Searcher itself only reads from one goroutine at a time
 type ServerReader interface {
 	ReadServer() (genericenricher.Server, error)
 	Close() error // Close server reader
 	Reset() error // Reset to start reading servers again
 }
 
+// FailureStage Stage at which searching a server (or reading from a ServerReader) failed
+type FailureStage int
+
+const (
+	// NoFailure No error occurred
+	NoFailure FailureStage = iota
+	// ConnectFailure Failed to connect to the server
+	ConnectFailure
+	// ReadFailure Failed while reading data from the server
+	ReadFailure
+	// ReaderFailure Failed to read the next server from a ServerReader
+	ReaderFailure
+	// ScopeFailure Server is outside the scope of the Searcher, see AddScope
+	ScopeFailure
+)
+
+func (stage FailureStage) String() string {
+	switch stage {
+	case NoFailure:
+		return "none"
+	case ConnectFailure:
+		return "connect"
+	case ReadFailure:
+		return "read"
+	case ReaderFailure:
+		return "reader"
+	case ScopeFailure:
+		return "scope"
+	default:
+		return fmt.Sprintf("FailureStage(%d)", int(stage))
+	}
+}
+
 // Match contains the matching server and regex matches
 type Match struct {
 	Matched bool
 	Server  genericenricher.Server
-	Matches []multiregex.Match // Matched regexes
+	Rules   []*Rule     // Matched rules.  Unless GetMatchedData is set this is only the first rule to match
+	Matches []RuleMatch // Matched data of each rule, when GetMatchedData is set
+	// Number of matches not in Matches because of Searcher.MaxMatchesPerRule
+	DroppedMatches int
+	// Connected to the server, how long it took to connect (or fail to), and the data read from it
+	Connected       bool
+	ConnectDuration time.Duration
+	BytesRead       int64
+	// Error that occurred while searching the server, or reading from the ServerReader.
+	// Note Matched can be true along with an error if the read failed after data matched
+	Err   error
+	Stage FailureStage // Stage the error occurred at
+	// ServerReader that failed when Stage is ReaderFailure.  Server will be nil in this case
+	Reader ServerReader
 }
 
 // Searcher struct that stores server readers and search rules
 type Searcher struct {
-	// Get the data the regex rules matched on (Match.Matches). This could miss some matches and will be slower as it won't stop on the first match.
+	// Get the data the regex rules matched on (Match.Matches). This will be slower as it won't stop on the first match.
+	// Like regexp.FindAll, matches of a rule do not overlap.  See ExhaustiveMatching to get every match
 	GetMatchedData bool
+	// Get every match of every rule, including matches that overlap or repeat, instead of just the first match.
+	// This implies GetMatchedData, which does not need to be set as well.  Use MaxMatchesPerRule to limit the matches kept for each server
+	ExhaustiveMatching bool
+	// Maximum number of matches of each rule to keep in Match.Matches, 0 for no limit.  Extra matches are counted in Match.DroppedMatches
+	MaxMatchesPerRule int
+	// Bytes of data before and after each match to include in Match.Matches, when GetMatchedData is set
+	MatchContextBefore int
+	MatchContextAfter  int
+	// Matches longer than this could be cut short when GetMatchedData is set.  Defaults to 4KB
+	MaxMatchLength int
 	// Return servers that did not match (with Match.Matched=false) for logging or progress tracking
 	ReturnNotMatchedServers bool
+	// Return errors from ServerReaders as a Match with Stage ReaderFailure and a nil Server.
+	// The ServerReader is closed after it returns an error
+	ReturnReaderErrors bool
 	// Limit of data to read on each server
 	ServerDataLimit int64
 	// Style of iterating over readers (breadth first or depth first)
 	ServerReaderIterationStyle IterationStyle
 	// Timeout to connect to each server
 	ServerTimeout time.Duration
-
-	serverReaders []ServerReader
+	// Number of servers to process at once.  Servers are still read from the ServerReaders in
+	// ServerReaderIterationStyle order, but matches will be returned in the order they finish.
+	Workers int
+	// File to periodically save the Session to while processing, so it can be resumed with Resume
+	CheckpointFile string
+	// How often to save the Session to CheckpointFile.  It is always saved once processing finishes
+	CheckpointInterval time.Duration
+	// Leave the matches already returned out of the Session, so checkpoints of long searches stay small.
+	// Matches returned before a checkpoint are then not in the saved Session.Matches
+	OmitSessionMatches bool
+	// Called with the Stats every ProgressInterval while processing, and once more when processing finishes.
+	// It is never called more than once at a time
+	ProgressFunc func(Stats)
+	// How often to call ProgressFunc
+	ProgressInterval time.Duration
+
+	serverReaders []*serverReaderEntry
 	servers       []genericenricher.Server
-	rules         multiregex.RuleSet
+	rules         []*Rule
+	scope         []net.IPNet            // Networks servers must be in, see AddScope
+	scopeResolver serverreaders.Resolver // Resolves hostnames of servers to check the scope, net.DefaultResolver if nil
+	hooks         []Hooks
+
+	// Session tracking
+	readLock      sync.Mutex               // Held while starting and finishing a read from a ServerReader, but not while waiting for it
+	stateLock     sync.Mutex               // Protects the fields below
+	processed     map[string]SessionServer // Servers searched that are not from a StatefulServerReader, whose state already has them
+	skip          map[string]bool          // Servers processed in a resumed session
+	pending       map[string]*pendingServer
+	emitted       []SessionMatch
+	checkpointErr error
+
+	statsLock sync.Mutex // Protects stats and the stats of each serverReaderEntry
+	stats     Stats
+}
+
+// serverReaderEntry ServerReader with the max number of its servers to process at once
+type serverReaderEntry struct {
+	reader      ServerReader
+	concurrency int  // 0 for no limit other than Searcher.Workers
+	finished    bool // Reader returned EOF or an error, protected by readLock
+	stateful    bool // Reader has state, so its servers are not kept in the Session once processed
+	stats       ReaderStats
+
+	// State of a StatefulServerReader from before the read in progress, protected by readLock.
+	// A Session uses it while reading instead of waiting for the read, as the reader may be past a server not pending yet
+	reading      bool
+	readState    []byte
+	readStateErr error
+}
+
+// job Server waiting to be processed by a worker
+type job struct {
+	server     genericenricher.Server
+	fromReader bool   // Server was read from a ServerReader (or is pending from a resumed session)
+	stateful   bool   // Server was read from a StatefulServerReader with state
+	match      *Match // Already known result to send instead of processing server
+	done       func() // Called once the server is processed
 }
 
 func NewSearcher() *Searcher {
-	s := &Searcher{ServerTimeout: defaultServerTimeout}
+	s := &Searcher{
+		ServerTimeout:      defaultServerTimeout,
+		Workers:            defaultWorkers,
+		CheckpointInterval: defaultCheckpointInterval,
+		ProgressInterval:   defaultProgressInterval,
+		MaxMatchLength:     defaultMaxMatchLength,
+	}
 	return s
 }
 
 // AddServerReader Add source of servers
 func (searcher *Searcher) AddServerReader(serverReader ServerReader) {
-	searcher.serverReaders = append(searcher.serverReaders, serverReader)
+	searcher.AddServerReaderWithConcurrency(serverReader, 0)
+}
+
+// AddServerReaderWithConcurrency Add source of servers, processing at most concurrency servers from it at once.
+// A concurrency of 0 means the reader is only limited by Workers
+func (searcher *Searcher) AddServerReaderWithConcurrency(serverReader ServerReader, concurrency int) {
+	searcher.serverReaders = append(searcher.serverReaders, &serverReaderEntry{
+		reader:      serverReader,
+		concurrency: concurrency,
+		stateful:    hasState(serverReader),
+		stats:       ReaderStats{Reader: serverReader},
+	})
 }
 
 // AddServer Add a single server
@@ -76,9 +222,9 @@ func (searcher *Searcher) AddServer(server genericenricher.Server) {
 	searcher.servers = append(searcher.servers, server)
 }
 
-// AddSearchRule Add search rule
+// AddSearchRule Add search rule from just a regex, see AddRule to include information about the rule
 func (searcher *Searcher) AddSearchRule(rule *regexp.Regexp) {
-	searcher.rules = append(searcher.rules, rule)
+	searcher.rules = append(searcher.rules, NewRule(rule))
 }
 
 // AddSearchRulesFromFile Reads file rules (regex rule per line)
@@ -88,13 +234,19 @@ func (searcher *Searcher) AddSearchRulesFromFile(filename string) error {
 	if err != nil {
 		return errors.New("error opening regex file")
 	}
+	defer inputRegex.Close()
 	return searcher.AddSearchRulesFromReader(inputRegex)
 }
 
-// AddSearchRulesFromReader Adds regex rules from a reader (regex rule per line)
+// AddSearchRulesFromReader Adds regex rules from a reader (regex rule per line).  Blank lines are skipped.
+// See AddRulePackFromFile for rules with names and other information
 func (searcher *Searcher) AddSearchRulesFromReader(reader io.Reader) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			// An empty regex would match everything
+			continue
+		}
 		regex, err := regexp.Compile(scanner.Text())
 		if err != nil {
 			return fmt.Errorf("invalid regex: `%s`", scanner.Text())
@@ -106,44 +258,168 @@ func (searcher *Searcher) AddSearchRulesFromReader(reader io.Reader) error {
 }
 
 // Process Get all servers and search each.
-// It first scans all single servers added, then goes depth/breadth for each server reader
+// It first scans all single servers added, then goes depth/breadth for each server reader.
+// Up to Workers servers are searched at once.  Canceling ctx closes the server readers to stop reads in progress.
 func (searcher *Searcher) Process(ctx context.Context) (matches chan *Match, err error) {
-	matches = make(chan *Match)
+	searcher.resetSession()
+	return searcher.process(ctx, nil), nil
+}
 
+// process Search pending servers, then the single servers, then each server reader
+func (searcher *Searcher) process(ctx context.Context, pending []genericenricher.Server) chan *Match {
+	matches := make(chan *Match)
+	jobs := make(chan *job)
+	searcher.resetStats()
+
+	// Start workers
+	workers := searcher.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if j.match != nil {
+					searcher.sendMatch(ctx, j.match, matches)
+				} else {
+					if searcher.processServer(ctx, j.server, matches) {
+						searcher.finishServer(j.server, j.fromReader, j.stateful)
+					}
+				}
+				j.done()
+			}
+		}()
+	}
+
+	// Periodically save checkpoints
+	stopCheckpoints := make(chan struct{})
+	if searcher.CheckpointFile != "" && searcher.CheckpointInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(searcher.CheckpointInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					searcher.checkpoint()
+				case <-stopCheckpoints:
+					return
+				}
+			}
+		}()
+	}
+
+	// Periodically report progress
+	stopProgress := make(chan struct{})
+	progressStopped := make(chan struct{})
+	go func() {
+		searcher.reportProgress(stopProgress)
+		close(progressStopped)
+	}()
+
+	// Close matches when all workers are done
+	go func() {
+		wg.Wait()
+		close(stopCheckpoints)
+		close(stopProgress)
+		<-progressStopped
+		if searcher.CheckpointFile != "" {
+			searcher.checkpoint()
+		}
+		searcher.updateStats(func(stats *Stats) {
+			stats.Finished = time.Now()
+		})
+		if searcher.ProgressFunc != nil {
+			searcher.ProgressFunc(searcher.Stats())
+		}
+		close(matches)
+	}()
+
+	// Stop reads in progress when the context is canceled, rather than waiting for them to return
+	dispatched := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			for _, serverReader := range searcher.serverReaders {
+				serverReader.reader.Close()
+			}
+		case <-dispatched:
+		}
+	}()
+
+	// Dispatch servers to workers
 	go func() {
-		defer close(matches)
+		defer close(jobs)
+		defer close(dispatched)
 		defer func() {
 			// Close all readers
 			for _, serverReader := range searcher.serverReaders {
-				serverReader.Close()
+				serverReader.reader.Close()
 			}
 		}()
 
+		// Process servers left over from a resumed session
+		for _, server := range pending {
+			if !searcher.dispatch(ctx, jobs, &job{server: server, fromReader: true, done: func() {}}) {
+				return
+			}
+		}
+
 		// Process each server
 		for _, server := range searcher.servers {
-			searcher.processServer(ctx, server, matches)
+			if searcher.alreadyProcessed(server) {
+				continue
+			}
+			if !searcher.dispatch(ctx, jobs, &job{server: server, done: func() {}}) {
+				return
+			}
 		}
 
+		// Limit how many servers of each reader are processed at once
+		slots := make([]chan struct{}, len(searcher.serverReaders))
+		for i, serverReader := range searcher.serverReaders {
+			if serverReader.concurrency > 0 {
+				slots[i] = make(chan struct{}, serverReader.concurrency)
+			}
+		}
+
+		// Signaled when a slot is released, so we can wait when every reader left is at its limit
+		freed := make(chan struct{}, 1)
+
 		// Process readers
 		if searcher.ServerReaderIterationStyle == BreadthFirst {
 			for {
 				// Keep looping over each reader until we've finished them all
-				finishedReaders := 0
-				for _, serverReader := range searcher.serverReaders {
-					// Read and process a server
-					if !searcher.processAServerReaderServer(ctx, serverReader, matches) {
+				finishedReaders, busyReaders := 0, 0
+				for i, serverReader := range searcher.serverReaders {
+					// Only this goroutine takes slots, so a reader with none free can't get one until a worker finishes
+					if slots[i] != nil && len(slots[i]) == cap(slots[i]) && !searcher.readerFinished(serverReader) {
+						busyReaders++
+						continue
+					}
+					// Read and dispatch a server
+					if !searcher.processAServerReaderServer(ctx, serverReader, slots[i], freed, jobs) {
 						// Done reading this
 						finishedReaders++
 					}
 				}
-				if finishedReaders == len(searcher.serverReaders) {
+				if finishedReaders == len(searcher.serverReaders) || ctx.Err() != nil {
 					break
 				}
+				if finishedReaders+busyReaders == len(searcher.serverReaders) {
+					// Wait for a worker to finish a server of a busy reader
+					select {
+					case <-freed:
+					case <-ctx.Done():
+					}
+				}
 			}
 		} else if searcher.ServerReaderIterationStyle == DepthFirst {
-			for _, serverReader := range searcher.serverReaders {
+			for i, serverReader := range searcher.serverReaders {
 				// Process all servers in this reader
-				for searcher.processAServerReaderServer(ctx, serverReader, matches) {
+				for searcher.processAServerReaderServer(ctx, serverReader, slots[i], freed, jobs) {
 				}
 			}
 		} else {
@@ -152,41 +428,135 @@ func (searcher *Searcher) Process(ctx context.Context) (matches chan *Match, err
 
 	}()
 
-	return matches, nil
+	return matches
 }
 
-// processAServerReaderServer Read single server from ServerReader and process, returns true if there is more to be read
-func (searcher *Searcher) processAServerReaderServer(ctx context.Context, serverReader ServerReader, matches chan *Match) bool {
+// dispatch Send job to a worker, returns false if the context was canceled first
+func (searcher *Searcher) dispatch(ctx context.Context, jobs chan *job, j *job) bool {
+	select {
+	case jobs <- j:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// processAServerReaderServer Read single server from ServerReader and send it to a worker, returns true if there is more to be read.
+// slots limits the number of servers from this reader being processed at once, nil for no limit.  freed is signaled when a slot is released
+func (searcher *Searcher) processAServerReaderServer(ctx context.Context, entry *serverReaderEntry, slots, freed chan struct{}, jobs chan *job) bool {
+	serverReader := entry.reader
+	if searcher.readerFinished(entry) {
+		return false
+	}
+
+	// Wait for a free slot before reading the next server
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			serverReader.Close()
+			return false
+		}
+	}
+	release := func() {
+		if slots != nil {
+			<-slots
+			select {
+			case freed <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	// Keep the state from before the read, and mark the server pending when it is read, so a checkpoint never sees
+	// a reader past a server that is not pending
+	searcher.readLock.Lock()
+	if statefulReader, ok := serverReader.(StatefulServerReader); ok {
+		entry.readState, entry.readStateErr = statefulReader.State()
+	}
+	entry.reading = true
+	searcher.readLock.Unlock()
 	server, err := serverReader.ReadServer()
+	searcher.readLock.Lock()
+	entry.reading = false
+	if err != nil && ctx.Err() != nil {
+		// The read was stopped by closing the reader when the context was canceled, so it has not finished
+		searcher.readLock.Unlock()
+		release()
+		return false
+	}
+	if err != nil {
+		entry.finished = true
+	}
+	skip := server != nil && searcher.alreadyProcessed(server)
+	if server != nil && !skip {
+		searcher.addPending(server)
+	}
+	searcher.readLock.Unlock()
+	searcher.addReaderStats(entry, server != nil, err)
+
 	if err != nil && err != io.EOF {
 		// Close this reader
+		release()
 		serverReader.Close()
+		searcher.callHooks(func(hooks Hooks) { hooks.OnReaderError(serverReader, err) })
+
+		// Let a worker return the error so it stays in order with the servers before it
+		if searcher.ReturnReaderErrors {
+			searcher.dispatch(ctx, jobs, &job{match: &Match{Reader: serverReader, Err: err, Stage: ReaderFailure}, done: func() {}})
+		}
 		return false
 	}
 
-	if server != nil {
-		searcher.processServer(ctx, server, matches)
+	if server != nil && !skip {
+		if !searcher.dispatch(ctx, jobs, &job{server: server, fromReader: true, stateful: entry.stateful, done: release}) {
+			release()
+			serverReader.Close()
+			return false
+		}
+	} else {
+		release()
 	}
 
 	if err == io.EOF {
 		// Close this reader
 		serverReader.Close()
+		searcher.callHooks(func(hooks Hooks) { hooks.OnReaderEOF(serverReader) })
 		return false
 	}
 
 	return true
 }
 
-// processServer Given a server send the associated match
-func (searcher *Searcher) processServer(ctx context.Context, server genericenricher.Server, matches chan *Match) {
+// readerFinished Check if the reader already returned EOF or an error
+func (searcher *Searcher) readerFinished(entry *serverReaderEntry) bool {
+	searcher.readLock.Lock()
+	defer searcher.readLock.Unlock()
+	return entry.finished
+}
+
+// processServer Given a server send the associated match.
+// Returns false if the context was canceled before the server was finished
+func (searcher *Searcher) processServer(ctx context.Context, server genericenricher.Server, matches chan *Match) bool {
 	match := searcher.searchServer(ctx, server, searcher.GetMatchedData)
+	if ctx.Err() != nil {
+		// Search was likely cut short
+		return false
+	}
 	if match.Matched || searcher.ReturnNotMatchedServers {
-		// Send match
-		select {
-		case matches <- match:
-		case <-ctx.Done():
-			return
-		}
+		return searcher.sendMatch(ctx, match, matches)
+	}
+	return true
+}
+
+// sendMatch Send match unless the context is canceled first, returns true if it was sent
+func (searcher *Searcher) sendMatch(ctx context.Context, match *Match, matches chan *Match) bool {
+	select {
+	case matches <- match:
+		searcher.addEmitted(match)
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
@@ -195,14 +565,44 @@ func (searcher *Searcher) searchServer(ctx context.Context, server genericenrich
 	match := &Match{}
 	match.Server = server
 	match.Matched = false
+	searcher.updateStats(func(stats *Stats) {
+		stats.Attempted++
+	})
+	searcher.callHooks(func(hooks Hooks) { hooks.OnServerStart(server) })
+	defer func() {
+		searcher.addMatchStats(match)
+		searcher.callHooks(func(hooks Hooks) { hooks.OnServerDone(match) })
+	}()
+
+	// Never connect to servers outside the scope
+	if !searcher.serverInScope(ctx, server) {
+		match.Err = ErrOutOfScope
+		match.Stage = ScopeFailure
+		return match
+	}
+
+	// Get rules for this type of server
+	rules := searcher.rulesFor(server.Type())
+	if len(rules) == 0 {
+		return match
+	}
 
 	// Check if we can connect
 	c, cancel := context.WithTimeout(ctx, searcher.ServerTimeout)
+	connectStart := time.Now()
 	err := server.Connect(c)
+	match.ConnectDuration = time.Since(connectStart)
 	if err != nil {
 		cancel()
+		match.Err = err
+		match.Stage = ConnectFailure
+		searcher.callHooks(func(hooks Hooks) { hooks.OnConnectError(server, err) })
 		return match
 	}
+	match.Connected = true
+	searcher.updateStats(func(stats *Stats) {
+		stats.Connected++
+	})
 
 	// Create new reader if we have a limit
 	var serverReader io.ReadCloser
@@ -211,31 +611,86 @@ func (searcher *Searcher) searchServer(ctx context.Context, server genericenrich
 	} else {
 		serverReader = ioutil.NopCloser(io.LimitReader(server, searcher.ServerDataLimit))
 	}
+	statsReader := &statsReader{ReadCloser: serverReader, searcher: searcher}
+	serverReader = statsReader
+	errReader := &errorReader{ReadCloser: serverReader}
+	serverReader = errReader
 
-	if searcher.GetMatchedData {
+	if searcher.GetMatchedData || searcher.ExhaustiveMatching {
 		// Get the matched data
-		matchesChan := searcher.rules.GetMatchedDataReader(ctx, serverReader)
-
-		// Read all matched rules and data
-		ms := []multiregex.Match{}
-		for m := range matchesChan {
-			ms = append(ms, m)
+		matcher := &streamMatcher{
+			rules:          rules,
+			contextBefore:  searcher.MatchContextBefore,
+			contextAfter:   searcher.MatchContextAfter,
+			maxMatchLength: searcher.MaxMatchLength,
+			overlapping:    searcher.ExhaustiveMatching,
 		}
-		match.Matches = ms
+		ruleMatches := map[*Rule]int{}
+		matcher.find(ctx, serverReader, func(m RuleMatch) {
+			if ruleMatches[m.Rule] == 0 {
+				match.Rules = append(match.Rules, m.Rule)
+			}
+			ruleMatches[m.Rule]++
+			if searcher.MaxMatchesPerRule > 0 && ruleMatches[m.Rule] > searcher.MaxMatchesPerRule {
+				match.DroppedMatches++
+				return
+			}
+			match.Matches = append(match.Matches, m)
+			searcher.callHooks(func(hooks Hooks) { hooks.OnRuleHit(server, m) })
+		})
 
 		// Check if we got any
-		if len(match.Matches) > 0 {
+		if len(match.Rules) > 0 {
 			match.Matched = true
 		}
 	} else {
-		// Check if we match
-		if searcher.rules.MatchesRulesReader(ctx, serverReader) {
+		// Check if we match, stopping at the first matched rule
+		regexes, regexRules := ruleSet(rules)
+		matchCtx, matchCancel := context.WithCancel(ctx)
+		for regex := range regexes.GetMatchedRulesReader(matchCtx, serverReader) {
+			match.Rules = append(match.Rules, regexRules[regex])
 			match.Matched = true
+			searcher.callHooks(func(hooks Hooks) { hooks.OnRuleHit(server, RuleMatch{Rule: regexRules[regex]}) })
+			break
 		}
+		matchCancel()
+	}
+
+	// Check for read errors before canceling, which would cause its own
+	if err := errReader.Err(); err != nil {
+		match.Err = err
+		match.Stage = ReadFailure
 	}
 
 	// Cancel connection to server
 	cancel()
+	match.BytesRead = statsReader.BytesRead()
 
 	return match
 }
+
+// errorReader Records the first error other than EOF returned by the wrapped reader
+type errorReader struct {
+	io.ReadCloser
+	lock sync.Mutex
+	err  error
+}
+
+func (r *errorReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		r.lock.Lock()
+		if r.err == nil {
+			r.err = err
+		}
+		r.lock.Unlock()
+	}
+	return n, err
+}
+
+// Err First error returned by the wrapped reader
+func (r *errorReader) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
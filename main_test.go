@@ -1,9 +1,17 @@
 package serverpatdown
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -148,3 +156,324 @@ func TestProcessWithReader(t *testing.T) {
 		t.Errorf("No servers read")
 	}
 }
+
+// serverListReader ServerReader over a fixed list of servers
+type serverListReader struct {
+	servers []genericenricher.Server
+	i       int
+}
+
+func (l *serverListReader) ReadServer() (genericenricher.Server, error) {
+	if l.i >= len(l.servers) {
+		return nil, io.EOF
+	}
+	l.i++
+	return l.servers[l.i-1], nil
+}
+
+func (l *serverListReader) Close() error {
+	l.i = len(l.servers)
+	return nil
+}
+
+func (l *serverListReader) Reset() error {
+	l.i = 0
+	return nil
+}
+
+// slowServer Test http server that takes delay to respond, and tracks the max number of requests at once
+func slowServer(delay time.Duration) (ts *httptest.Server, maxInFlight *int32) {
+	var inFlight int32
+	maxInFlight = new(int32)
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(maxInFlight, max, current) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		fmt.Fprintln(w, "secret")
+	}))
+	return ts, maxInFlight
+}
+
+func TestProcessWorkers(t *testing.T) {
+	ts, maxInFlight := slowServer(time.Millisecond * 200)
+	defer ts.Close()
+
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.Workers = 8
+	for i := 0; i < 8; i++ {
+		server, err := genericenricher.GetServerWithType(ts.URL, enrichers.HTTP)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		searcher.AddServer(server)
+	}
+
+	start := time.Now()
+	matchedServers, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	count := 0
+	for range matchedServers {
+		count++
+	}
+	if count != 8 {
+		t.Errorf("Did not match all servers, got %d", count)
+	}
+	if time.Since(start) > time.Millisecond*200*4 {
+		t.Errorf("Servers were not processed concurrently")
+	}
+	if atomic.LoadInt32(maxInFlight) < 2 {
+		t.Errorf("Servers were not processed concurrently")
+	}
+}
+
+func TestProcessReaderConcurrency(t *testing.T) {
+	ts, maxInFlight := slowServer(time.Millisecond * 50)
+	defer ts.Close()
+
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.Workers = 8
+
+	reader := &serverListReader{}
+	for i := 0; i < 8; i++ {
+		server, err := genericenricher.GetServerWithType(ts.URL, enrichers.HTTP)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		reader.servers = append(reader.servers, server)
+	}
+	searcher.AddServerReaderWithConcurrency(reader, 2)
+
+	matchedServers, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	count := 0
+	for range matchedServers {
+		count++
+	}
+	if count != 8 {
+		t.Errorf("Did not match all servers, got %d", count)
+	}
+	if atomic.LoadInt32(maxInFlight) > 2 {
+		t.Errorf("Processed %d servers at once from reader limited to 2", atomic.LoadInt32(maxInFlight))
+	}
+}
+
+func TestProcessReaderConcurrencyBreadthFirst(t *testing.T) {
+	slow, _ := slowServer(time.Millisecond * 200)
+	defer slow.Close()
+	fast, _ := slowServer(0)
+	defer fast.Close()
+
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.Workers = 4
+
+	// A reader limited to one server at a time should not hold up the other reader
+	slowReader := &serverListReader{}
+	fastReader := &serverListReader{}
+	for i := 0; i < 3; i++ {
+		server, err := genericenricher.GetServerWithType(slow.URL, enrichers.HTTP)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		slowReader.servers = append(slowReader.servers, server)
+	}
+	for i := 0; i < 4; i++ {
+		server, err := genericenricher.GetServerWithType(fast.URL, enrichers.HTTP)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		fastReader.servers = append(fastReader.servers, server)
+	}
+	searcher.AddServerReaderWithConcurrency(slowReader, 1)
+	searcher.AddServerReader(fastReader)
+
+	matchedServers, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	fastMatched, count := 0, 0
+	for match := range matchedServers {
+		count++
+		if match.Server.GetConnectString() == fastReader.servers[0].GetConnectString() {
+			fastMatched++
+		} else if fastMatched != 4 {
+			t.Errorf("Matched server of limited reader before other reader finished, %d of 4 done", fastMatched)
+		}
+	}
+	if count != 7 {
+		t.Errorf("Did not match all servers, got %d", count)
+	}
+}
+
+// fakeServer genericenricher.Server serving fixed data, optionally failing to connect or read
+type fakeServer struct {
+	data       []byte
+	connectErr error
+	readErr    error  // Returned once data is read instead of EOF
+	ip         net.IP // Defaults to 127.0.0.1
+	host       string // Hostname in the connection string instead of the ip
+	connects   int
+	reader     *bytes.Reader
+}
+
+func (f *fakeServer) GetIP() net.IP {
+	if f.ip == nil {
+		return net.IP{127, 0, 0, 1}
+	}
+	return f.ip
+}
+func (f *fakeServer) GetPort() uint16            { return 1 }
+func (f *fakeServer) IsConnected() bool          { return f.reader != nil }
+func (f *fakeServer) Type() enrichers.ServerType { return enrichers.Unknown }
+func (f *fakeServer) Close() error               { return nil }
+
+func (f *fakeServer) GetConnectString() string {
+	if f.host != "" {
+		return "fake://" + f.host + ":1"
+	}
+	return "fake://" + f.GetIP().String() + ":1"
+}
+
+func (f *fakeServer) Connect(context.Context) error {
+	f.connects++
+	f.reader = nil
+	return f.connectErr
+}
+
+func (f *fakeServer) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		f.reader = bytes.NewReader(f.data)
+	}
+	n, err := f.reader.Read(p)
+	if err == io.EOF && f.readErr != nil {
+		return n, f.readErr
+	}
+	return n, err
+}
+
+func (f *fakeServer) ResetReader() error {
+	f.reader = nil
+	return nil
+}
+
+// errorServerReader ServerReader that always fails
+type errorServerReader struct {
+	err error
+}
+
+func (e *errorServerReader) ReadServer() (genericenricher.Server, error) { return nil, e.err }
+func (e *errorServerReader) Close() error                                { return nil }
+func (e *errorServerReader) Reset() error                                { return nil }
+
+func TestProcessErrors(t *testing.T) {
+	connectErr := errors.New("connection refused")
+	readErr := errors.New("connection reset")
+	readerErr := errors.New("quota exhausted")
+
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.ReturnNotMatchedServers = true
+	searcher.ReturnReaderErrors = true
+	searcher.AddServer(&fakeServer{data: []byte("nothing here")})
+	searcher.AddServer(&fakeServer{connectErr: connectErr})
+	searcher.AddServer(&fakeServer{data: []byte("partial data"), readErr: readErr})
+	reader := &errorServerReader{err: readerErr}
+	searcher.AddServerReader(reader)
+
+	matches, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	got := []*Match{}
+	for match := range matches {
+		got = append(got, match)
+	}
+	if len(got) != 4 {
+		t.Errorf("Expected 4 results, got %d", len(got))
+		return
+	}
+
+	expected := []struct {
+		err   error
+		stage FailureStage
+	}{
+		{nil, NoFailure},
+		{connectErr, ConnectFailure},
+		{readErr, ReadFailure},
+		{readerErr, ReaderFailure},
+	}
+	for i, e := range expected {
+		if got[i].Err != e.err || got[i].Stage != e.stage {
+			t.Errorf("Result %d: expected %v at stage %s, got %v at stage %s", i, e.err, e.stage, got[i].Err, got[i].Stage)
+		}
+	}
+	if got[3].Server != nil || got[3].Reader != reader {
+		t.Errorf("Reader failure should include reader and no server")
+	}
+}
+
+// blockingServerReader ServerReader whose reads wait until it is closed
+type blockingServerReader struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (b *blockingServerReader) ReadServer() (genericenricher.Server, error) {
+	<-b.closed
+	return nil, io.EOF
+}
+func (b *blockingServerReader) Close() error { b.once.Do(func() { close(b.closed) }); return nil }
+func (b *blockingServerReader) Reset() error { return nil }
+
+func TestProcessCancelClosesReaders(t *testing.T) {
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.AddServerReader(&blockingServerReader{closed: make(chan struct{})})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	matches, err := searcher.Process(ctx)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+
+	// The read in progress is stopped by closing the reader
+	select {
+	case _, ok := <-matches:
+		if ok {
+			t.Errorf("Did not expect a match")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Read was not stopped when the context was canceled")
+	}
+
+	// The reader was stopped, so it is not finished
+	session, err := searcher.Session()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if session.Readers[0].Finished {
+		t.Errorf("Reader stopped by canceling should not be finished")
+	}
+}
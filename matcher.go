@@ -0,0 +1,197 @@
+package serverpatdown
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	defaultMatchChunkSize = 64 * 1024
+	defaultMaxMatchLength = 4 * 1024
+)
+
+// streamMatcher Finds the matches of a set of rules in a stream, along with their offset and context,
+// while only keeping a window of the stream in memory
+type streamMatcher struct {
+	rules          []*Rule
+	contextBefore  int
+	contextAfter   int
+	chunkSize      int  // Bytes to read each time the window is scanned
+	maxMatchLength int  // Matches longer than this may be cut short
+	overlapping    bool // Find a match starting at every position, instead of continuing after the end of each match
+}
+
+// find Call found with each match in offset order.  Returns the first error reading from reader other than EOF
+func (m *streamMatcher) find(ctx context.Context, reader io.Reader, found func(RuleMatch)) error {
+	chunkSize := m.chunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultMatchChunkSize
+	}
+	maxMatchLength := m.maxMatchLength
+	if maxMatchLength <= 0 {
+		maxMatchLength = defaultMaxMatchLength
+	}
+	// Data after a match we need before we know the match is complete
+	margin := maxMatchLength
+	if m.contextAfter > margin {
+		margin = m.contextAfter
+	}
+
+	var window []byte // Data of the stream starting at base
+	base := int64(0)
+	next := make([]int64, len(m.rules)) // Offset to continue searching from for each rule
+	done := false
+	var readErr error
+	for !done {
+		if ctx.Err() != nil {
+			return readErr
+		}
+
+		// Read the next chunk
+		start := len(window)
+		window = append(window, make([]byte, chunkSize)...)
+		n, err := io.ReadFull(reader, window[start:])
+		window = window[:start+n]
+		if err != nil {
+			done = true
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				readErr = err
+			}
+		}
+
+		// Search each rule from where it left off
+		hits := []RuleMatch{}
+		for i, rule := range m.rules {
+			from := int(next[i] - base)
+			for from <= len(window) {
+				loc := findFrom(rule.Regex, window, base, from)
+				if loc == nil {
+					if !done && len(window)-maxMatchLength > from {
+						// A match could still start in the last maxMatchLength bytes once we read more
+						from = len(window) - maxMatchLength
+					} else if done {
+						from = len(window)
+					}
+					break
+				}
+				matchStart, matchEnd := loc[0], loc[1]
+				if matchStart == matchEnd {
+					// Ignore empty matches
+					from = matchStart + 1
+					continue
+				}
+				if !done && matchEnd+margin > len(window) && matchEnd-matchStart < maxMatchLength {
+					// The match could continue, or we don't have the context after it yet, check again after the next read
+					from = matchStart
+					break
+				}
+				hits = append(hits, m.ruleMatch(rule, window, base, matchStart, matchEnd))
+				if m.overlapping {
+					// Next match can start at the next character
+					_, size := utf8.DecodeRune(window[matchStart:])
+					from = matchStart + size
+				} else {
+					from = matchEnd
+				}
+			}
+			if from > len(window) {
+				from = len(window)
+			}
+			next[i] = base + int64(from)
+		}
+
+		// Return hits in order of offset
+		sort.SliceStable(hits, func(i, j int) bool {
+			return hits[i].Offset < hits[j].Offset
+		})
+		for _, hit := range hits {
+			found(hit)
+		}
+
+		// Drop data we no longer need, keeping context for the next matches and the rune before them
+		keep := base + int64(len(window))
+		for _, offset := range next {
+			if offset < keep {
+				keep = offset
+			}
+		}
+		if m.contextBefore > utf8.UTFMax {
+			keep -= int64(m.contextBefore)
+		} else {
+			keep -= utf8.UTFMax
+		}
+		if keep > base {
+			window = append([]byte{}, window[keep-base:]...)
+			base = keep
+		}
+	}
+
+	return readErr
+}
+
+// continuedRegexes Cache of continuedRegex
+var continuedRegexes sync.Map
+
+// continuedRegex Get regex matching a rune followed by regex, with regex as the first group.
+// Searching with it from the rune before a position finds matches of regex starting at or after that position,
+// while ^, \A, and \b still see the data before it instead of treating the position as the start of the text
+func continuedRegex(regex *regexp.Regexp) *regexp.Regexp {
+	if continued, ok := continuedRegexes.Load(regex); ok {
+		return continued.(*regexp.Regexp)
+	}
+	continued := regexp.MustCompile(`(?s:.)(` + regex.String() + `)`)
+	continuedRegexes.Store(regex, continued)
+	return continued
+}
+
+// findFrom Get the location in window of the first match of regex starting at from or later, or nil if there is none.
+// base is the offset of window in the stream, and window must hold the rune before from unless from is the start of the stream,
+// so ^ and \A only match at the start of the stream
+func findFrom(regex *regexp.Regexp, window []byte, base int64, from int) []int {
+	if base+int64(from) == 0 {
+		return regex.FindIndex(window)
+	}
+	_, size := utf8.DecodeLastRune(window[:from])
+	lead := from - size
+	continued := continuedRegex(regex)
+	for {
+		loc := continued.FindSubmatchIndex(window[lead:])
+		if loc == nil {
+			return nil
+		}
+		matchStart, matchEnd := lead+loc[2], lead+loc[3]
+		if matchStart >= from {
+			return []int{matchStart, matchEnd}
+		}
+		// Drop the match starting before from, only possible with invalid UTF-8 before from
+		lead = matchStart
+	}
+}
+
+// ruleMatch Build the match of window[matchStart:matchEnd] with its context
+func (m *streamMatcher) ruleMatch(rule *Rule, window []byte, base int64, matchStart, matchEnd int) RuleMatch {
+	ruleMatch := RuleMatch{
+		Rule:   rule,
+		Data:   append([]byte{}, window[matchStart:matchEnd]...),
+		Offset: base + int64(matchStart),
+	}
+	if m.contextBefore > 0 {
+		beforeStart := matchStart - m.contextBefore
+		if beforeStart < 0 {
+			beforeStart = 0
+		}
+		ruleMatch.Before = append([]byte{}, window[beforeStart:matchStart]...)
+	}
+	if m.contextAfter > 0 {
+		afterEnd := matchEnd + m.contextAfter
+		if afterEnd > len(window) {
+			afterEnd = len(window)
+		}
+		ruleMatch.After = append([]byte{}, window[matchEnd:afterEnd]...)
+	}
+	return ruleMatch
+}
@@ -0,0 +1,258 @@
+package serverpatdown
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func findAll(t *testing.T, matcher *streamMatcher, reader io.Reader) []RuleMatch {
+	matches := []RuleMatch{}
+	err := matcher.find(context.Background(), reader, func(m RuleMatch) {
+		matches = append(matches, m)
+	})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	return matches
+}
+
+func TestStreamMatcherOffsets(t *testing.T) {
+	data := strings.Repeat("filler ", 50) + "key=abc123 " + strings.Repeat("x", 100) + " key=def456"
+	rule := NewRule(regexp.MustCompile(`key=\w+`))
+
+	// Use small chunks so matches straddle chunk boundaries
+	for _, chunkSize := range []int{1, 3, 7, 64, 4096} {
+		matcher := &streamMatcher{rules: []*Rule{rule}, contextBefore: 7, contextAfter: 3, chunkSize: chunkSize, maxMatchLength: 16}
+		matches := findAll(t, matcher, iotest.OneByteReader(strings.NewReader(data)))
+		if len(matches) != 2 {
+			t.Errorf("Chunk size %d: expected 2 matches, got %d", chunkSize, len(matches))
+			continue
+		}
+		for _, m := range matches {
+			if data[m.Offset:m.Offset+int64(len(m.Data))] != string(m.Data) {
+				t.Errorf("Chunk size %d: wrong offset %d for %s", chunkSize, m.Offset, m.Data)
+			}
+		}
+		if string(matches[0].Data) != "key=abc123" || string(matches[0].Before) != "filler " || string(matches[0].After) != " xx" {
+			t.Errorf("Chunk size %d: wrong context %q %q %q", chunkSize, matches[0].Before, matches[0].Data, matches[0].After)
+		}
+		if string(matches[1].Data) != "key=def456" || string(matches[1].Before) != "xxxxxx " || len(matches[1].After) != 0 {
+			t.Errorf("Chunk size %d: wrong context at end of data %q %q %q", chunkSize, matches[1].Before, matches[1].Data, matches[1].After)
+		}
+	}
+}
+
+func TestStreamMatcherMultipleRules(t *testing.T) {
+	data := "aaa bbb aaa ccc bbb"
+	rules := []*Rule{NewRule(regexp.MustCompile(`bbb`)), NewRule(regexp.MustCompile(`aaa`)), NewRule(regexp.MustCompile(`x*`))}
+	matcher := &streamMatcher{rules: rules, chunkSize: 2, maxMatchLength: 4}
+	matches := findAll(t, matcher, strings.NewReader(data))
+
+	// Matches should be in offset order, and the empty matches of x* ignored
+	expected := []int64{0, 4, 8, 16}
+	if len(matches) != len(expected) {
+		t.Errorf("Expected %d matches, got %d", len(expected), len(matches))
+		return
+	}
+	for i, m := range matches {
+		if m.Offset != expected[i] {
+			t.Errorf("Match %d: expected offset %d, got %d", i, expected[i], m.Offset)
+		}
+	}
+}
+
+func TestStreamMatcherWindow(t *testing.T) {
+	// Check the window stays small on a large stream
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	copy(data[len(data)/2:], "secret")
+	reader := &countingReader{reader: bytes.NewReader(data)}
+	matcher := &streamMatcher{rules: []*Rule{NewRule(regexp.MustCompile(`secret`))}, chunkSize: 1024, maxMatchLength: 64}
+	matches := findAll(t, matcher, reader)
+	if len(matches) != 1 || matches[0].Offset != int64(len(data)/2) {
+		t.Errorf("Did not find match in large stream")
+	}
+	if reader.maxRead > 2048 {
+		t.Errorf("Read %d bytes at once, expected chunks of 1024", reader.maxRead)
+	}
+}
+
+func TestStreamMatcherReadError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	matcher := &streamMatcher{rules: []*Rule{NewRule(regexp.MustCompile(`abc`))}}
+	matches := []RuleMatch{}
+	err := matcher.find(context.Background(), &fakeServer{data: []byte("abc"), readErr: readErr}, func(m RuleMatch) {
+		matches = append(matches, m)
+	})
+	if err != readErr {
+		t.Errorf("Expected read error, got %v", err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("Should still report matches before the error")
+	}
+}
+
+// countingReader Tracks the largest read
+type countingReader struct {
+	reader  io.Reader
+	maxRead int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if len(p) > c.maxRead {
+		c.maxRead = len(p)
+	}
+	return c.reader.Read(p)
+}
+
+func TestProcessMatchContext(t *testing.T) {
+	searcher := NewSearcher()
+	searcher.GetMatchedData = true
+	searcher.MatchContextBefore = 10
+	searcher.MatchContextAfter = 2
+	searcher.AddSearchRule(regexp.MustCompile(`"password":"\w+"`))
+	searcher.AddServer(&fakeServer{data: []byte(`{"user":"admin","password":"hunter2"}`)})
+
+	matches, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	count := 0
+	for match := range matches {
+		count++
+		if len(match.Matches) != 1 {
+			t.Errorf("Expected 1 match, got %d", len(match.Matches))
+			continue
+		}
+		m := match.Matches[0]
+		if m.Offset != 16 || string(m.Before) != `":"admin",` || string(m.After) != "}" {
+			t.Errorf("Wrong offset or context: %d %q %q", m.Offset, m.Before, m.After)
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 matched server, got %d", count)
+	}
+}
+
+func TestStreamMatcherAnchors(t *testing.T) {
+	data := "foofoo foo,foo\nfoo xfoo"
+	for _, pattern := range []string{`^foo`, `\Afoo`, `\bfoo`, `foo\b`, `\Bfoo`, `(?m)^foo`, `^foo|\bfoo`} {
+		expected := regexp.MustCompile(pattern).FindAllIndex([]byte(data), -1)
+		// Use small chunks so matches and the rune before them straddle chunk boundaries
+		for _, chunkSize := range []int{1, 2, 3, 4, 7, 1024} {
+			matcher := &streamMatcher{rules: []*Rule{NewRule(regexp.MustCompile(pattern))}, chunkSize: chunkSize, maxMatchLength: 4}
+			matches := findAll(t, matcher, iotest.OneByteReader(strings.NewReader(data)))
+			offsets := []int64{}
+			for _, m := range matches {
+				offsets = append(offsets, m.Offset)
+			}
+			expectedOffsets := []int64{}
+			for _, loc := range expected {
+				expectedOffsets = append(expectedOffsets, int64(loc[0]))
+			}
+			if !reflect.DeepEqual(offsets, expectedOffsets) {
+				t.Errorf("%s with chunk size %d: expected matches at %v, got %v", pattern, chunkSize, expectedOffsets, offsets)
+			}
+		}
+	}
+}
+
+func TestStreamMatcherOverlapping(t *testing.T) {
+	data := strings.Repeat("abab", 20)
+	rule := NewRule(regexp.MustCompile(`aba`))
+
+	// Non overlapping
+	matcher := &streamMatcher{rules: []*Rule{rule}, chunkSize: 5, maxMatchLength: 8}
+	if matches := findAll(t, matcher, strings.NewReader(data)); len(matches) != 20 {
+		t.Errorf("Expected 20 non overlapping matches, got %d", len(matches))
+	}
+
+	// Every match, across chunk boundaries
+	for _, chunkSize := range []int{1, 2, 5, 1024} {
+		matcher = &streamMatcher{rules: []*Rule{rule}, chunkSize: chunkSize, maxMatchLength: 8, overlapping: true}
+		matches := findAll(t, matcher, strings.NewReader(data))
+		if len(matches) != 39 {
+			t.Errorf("Chunk size %d: expected 39 overlapping matches, got %d", chunkSize, len(matches))
+			continue
+		}
+		for i, m := range matches {
+			if m.Offset != int64(i*2) {
+				t.Errorf("Chunk size %d: match %d at offset %d", chunkSize, i, m.Offset)
+				break
+			}
+		}
+	}
+
+	// Multi byte characters
+	matcher = &streamMatcher{rules: []*Rule{NewRule(regexp.MustCompile(`é+`))}, overlapping: true}
+	matches := findAll(t, matcher, strings.NewReader("éé"))
+	if len(matches) != 2 || string(matches[1].Data) != "é" {
+		t.Errorf("Did not advance by character: %v", matches)
+	}
+}
+
+func TestStreamMatcherOverlappingWordBoundary(t *testing.T) {
+	pack, err := LoadRulePack("testdata/rulepacks/common.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rule *Rule
+	for _, r := range pack.Rules {
+		if r.ID == "internal-hostname" {
+			rule = r
+		}
+	}
+	if rule == nil {
+		t.Fatal("internal-hostname rule not found")
+	}
+
+	// Suffixes of a match such as b.internal start inside a word, so should not match
+	data := "host=db.internal; name=api1.internal"
+	for _, chunkSize := range []int{1, 3, 8, 1024} {
+		matcher := &streamMatcher{rules: []*Rule{rule}, chunkSize: chunkSize, maxMatchLength: 32, overlapping: true}
+		matches := findAll(t, matcher, strings.NewReader(data))
+		found := []string{}
+		for _, m := range matches {
+			found = append(found, string(m.Data))
+		}
+		if !reflect.DeepEqual(found, []string{"db.internal", "api1.internal"}) {
+			t.Errorf("Chunk size %d: expected only whole hostnames, got %q", chunkSize, found)
+		}
+	}
+}
+
+func TestProcessExhaustiveMatching(t *testing.T) {
+	searcher := NewSearcher()
+	searcher.ExhaustiveMatching = true
+	searcher.MaxMatchesPerRule = 3
+	repeated := NewRule(regexp.MustCompile(`token`))
+	once := NewRule(regexp.MustCompile(`admin`))
+	searcher.AddRule(repeated)
+	searcher.AddRule(once)
+	searcher.AddServer(&fakeServer{data: []byte("admin token token token token token")})
+
+	matches, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	for match := range matches {
+		counts := map[*Rule]int{}
+		for _, m := range match.Matches {
+			counts[m.Rule]++
+		}
+		if counts[repeated] != 3 || counts[once] != 1 || match.DroppedMatches != 2 {
+			t.Errorf("Did not limit matches per rule: %v, dropped %d", counts, match.DroppedMatches)
+		}
+		if len(match.Rules) != 2 {
+			t.Errorf("Expected 2 matched rules, got %d", len(match.Rules))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package metrics Prometheus metrics of a serverpatdown.Searcher
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/serverpatdown"
+)
+
+const (
+	namespace = "serverpatdown"
+	// Path Path the metrics are served at by Serve and ListenAndServe
+	Path = "/metrics"
+)
+
+// Metrics Prometheus metrics fed by the hooks of a Searcher, see New.  Implements prometheus.Collector
+//
+//	serverpatdown_servers_processed_total{outcome}  Servers searched by outcome: matched, not_matched, or the stage of the error (connect, read, scope)
+//	serverpatdown_connect_duration_seconds          Time taken to connect to each server, including failed connections
+//	serverpatdown_server_bytes_read                 Data read from each server connected to
+//	serverpatdown_rule_hits_total{rule}             Matches of each rule by name.  Unless GetMatchedData is set only the first rule to match a server is counted
+//	serverpatdown_reader_errors_total               Errors reading from ServerReaders
+//	serverpatdown_active_workers                    Servers being searched
+//	serverpatdown_reader_queue_depth                Servers read from ServerReaders that are waiting to be searched or being searched
+//	serverpatdown_reader_remaining_targets          Targets left to read from ServerReaders that can report it, see serverpatdown.ProgressReader
+type Metrics struct {
+	serverpatdown.NopHooks
+	registry *prometheus.Registry
+
+	serversProcessed *prometheus.CounterVec
+	connectDuration  prometheus.Histogram
+	serverBytesRead  prometheus.Histogram
+	ruleHits         *prometheus.CounterVec
+	readerErrors     prometheus.Counter
+	activeWorkers    prometheus.Gauge
+	queueDepth       prometheus.GaugeFunc
+	remainingTargets prometheus.GaugeFunc
+}
+
+// New Create metrics of a searcher, adding them to its hooks.  Serve them with Handler or ListenAndServe,
+// or register them with another prometheus.Registerer
+func New(searcher *serverpatdown.Searcher) *Metrics {
+	m := &Metrics{}
+	m.serversProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "servers_processed_total",
+		Help:      "Servers searched by outcome: matched, not_matched, or the stage of the error.",
+	}, []string{"outcome"})
+	m.connectDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "connect_duration_seconds",
+		Help:      "Time taken to connect to each server, including failed connections.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	m.serverBytesRead = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "server_bytes_read",
+		Help:      "Data read from each server connected to.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+	m.ruleHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_hits_total",
+		Help:      "Matches of each rule.",
+	}, []string{"rule"})
+	m.readerErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reader_errors_total",
+		Help:      "Errors reading from server readers.",
+	})
+	m.activeWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_workers",
+		Help:      "Servers being searched.",
+	})
+	m.queueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reader_queue_depth",
+		Help:      "Servers read from server readers that are waiting to be searched or being searched.",
+	}, func() float64 {
+		return float64(searcher.Stats().Pending)
+	})
+	m.remainingTargets = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reader_remaining_targets",
+		Help:      "Targets left to read from server readers that can report it.",
+	}, func() float64 {
+		remaining, _ := searcher.Stats().Remaining()
+		return float64(remaining)
+	})
+
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(m)
+	searcher.AddHooks(m)
+	return m
+}
+
+// collectors Get each of the metrics
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.serversProcessed, m.connectDuration, m.serverBytesRead, m.ruleHits,
+		m.readerErrors, m.activeWorkers, m.queueDepth, m.remainingTargets,
+	}
+}
+
+// Describe Implements prometheus.Collector
+func (m *Metrics) Describe(descs chan<- *prometheus.Desc) {
+	for _, collector := range m.collectors() {
+		collector.Describe(descs)
+	}
+}
+
+// Collect Implements prometheus.Collector
+func (m *Metrics) Collect(metrics chan<- prometheus.Metric) {
+	for _, collector := range m.collectors() {
+		collector.Collect(metrics)
+	}
+}
+
+// OnServerStart Implements serverpatdown.Hooks
+func (m *Metrics) OnServerStart(server genericenricher.Server) {
+	m.activeWorkers.Inc()
+}
+
+// OnRuleHit Implements serverpatdown.Hooks
+func (m *Metrics) OnRuleHit(server genericenricher.Server, match serverpatdown.RuleMatch) {
+	name := match.Rule.Name
+	if name == "" {
+		name = match.Rule.ID
+	}
+	m.ruleHits.WithLabelValues(name).Inc()
+}
+
+// OnServerDone Implements serverpatdown.Hooks
+func (m *Metrics) OnServerDone(match *serverpatdown.Match) {
+	m.activeWorkers.Dec()
+
+	outcome := "not_matched"
+	if match.Matched {
+		outcome = "matched"
+	} else if match.Err != nil {
+		outcome = match.Stage.String()
+	}
+	m.serversProcessed.WithLabelValues(outcome).Inc()
+
+	if match.Connected || match.Stage == serverpatdown.ConnectFailure {
+		m.connectDuration.Observe(match.ConnectDuration.Seconds())
+	}
+	if match.Connected {
+		m.serverBytesRead.Observe(float64(match.BytesRead))
+	}
+}
+
+// OnReaderError Implements serverpatdown.Hooks
+func (m *Metrics) OnReaderError(reader serverpatdown.ServerReader, err error) {
+	m.readerErrors.Inc()
+}
+
+// Handler Get http handler serving the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve Serve the metrics at Path on listener until ctx is canceled
+func (m *Metrics) Serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle(Path, m.Handler())
+	server := &http.Server{Handler: mux}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			server.Close()
+		case <-stopped:
+		}
+	}()
+
+	err := server.Serve(listener)
+	if err == http.ErrServerClosed && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// ListenAndServe Serve the metrics at Path on the TCP address addr, such as ":9090", until ctx is canceled
+func (m *Metrics) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return m.Serve(ctx, listener)
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+	"github.com/vertoforce/serverpatdown"
+)
+
+// fakeServer Server with fixed data
+type fakeServer struct {
+	ip         net.IP
+	data       []byte
+	connectErr error
+	reader     *bytes.Reader
+}
+
+func (f *fakeServer) GetIP() net.IP              { return f.ip }
+func (f *fakeServer) GetPort() uint16            { return 1 }
+func (f *fakeServer) GetConnectString() string   { return "fake://" + f.ip.String() + ":1" }
+func (f *fakeServer) IsConnected() bool          { return f.reader != nil }
+func (f *fakeServer) Type() enrichers.ServerType { return enrichers.Unknown }
+func (f *fakeServer) Close() error               { return nil }
+func (f *fakeServer) Connect(context.Context) error {
+	f.reader = bytes.NewReader(f.data)
+	return f.connectErr
+}
+func (f *fakeServer) Read(p []byte) (int, error) { return f.reader.Read(p) }
+func (f *fakeServer) ResetReader() error         { f.reader = bytes.NewReader(f.data); return nil }
+
+// errorReader ServerReader that always fails
+type errorReader struct{}
+
+func (errorReader) ReadServer() (genericenricher.Server, error) {
+	return nil, errors.New("quota exhausted")
+}
+func (errorReader) Close() error { return nil }
+func (errorReader) Reset() error { return nil }
+
+func newSearcher() *serverpatdown.Searcher {
+	searcher := serverpatdown.NewSearcher()
+	searcher.AddRule(&serverpatdown.Rule{ID: "secret", Name: "Secret", Regex: regexp.MustCompile(`secret`)})
+	searcher.AddServer(&fakeServer{ip: net.IP{127, 0, 0, 1}, data: []byte("a secret")})
+	searcher.AddServer(&fakeServer{ip: net.IP{127, 0, 0, 2}, data: []byte("nothing here")})
+	searcher.AddServer(&fakeServer{ip: net.IP{127, 0, 0, 3}, connectErr: errors.New("refused")})
+	searcher.AddServerReader(errorReader{})
+	return searcher
+}
+
+func TestMetrics(t *testing.T) {
+	searcher := newSearcher()
+	m := New(searcher)
+	matches, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range matches {
+	}
+
+	counters := map[string]float64{
+		"matched":     testutil.ToFloat64(m.serversProcessed.WithLabelValues("matched")),
+		"not_matched": testutil.ToFloat64(m.serversProcessed.WithLabelValues("not_matched")),
+		"connect":     testutil.ToFloat64(m.serversProcessed.WithLabelValues("connect")),
+		"rule hits":   testutil.ToFloat64(m.ruleHits.WithLabelValues("Secret")),
+		"errors":      testutil.ToFloat64(m.readerErrors),
+	}
+	for name, value := range counters {
+		if value != 1 {
+			t.Errorf("Expected 1 for %s, got %v", name, value)
+		}
+	}
+	if workers := testutil.ToFloat64(m.activeWorkers); workers != 0 {
+		t.Errorf("Expected no active workers, got %v", workers)
+	}
+
+	// Check the histograms in the served metrics
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	body := get(t, server.URL)
+	for _, line := range []string{
+		"serverpatdown_connect_duration_seconds_count 3",
+		"serverpatdown_server_bytes_read_sum 20",
+		"serverpatdown_server_bytes_read_count 2",
+		"serverpatdown_reader_queue_depth 0",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics do not have %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	m := New(newSearcher())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- m.Serve(ctx, listener)
+	}()
+
+	body := get(t, "http://"+listener.Addr().String()+Path)
+	if !strings.Contains(body, "serverpatdown_active_workers 0") {
+		t.Errorf("Did not serve metrics:\n%s", body)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Serve should stop without error, got %v", err)
+	}
+}
+
+// get Get the body of a url
+func get(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
@@ -0,0 +1,83 @@
+package output
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vertoforce/serverpatdown"
+)
+
+// CSVHeader Columns written by CSVWriter, the fields of Finding.  Tags are separated by ";"
+var CSVHeader = []string{
+	"ConnectString", "ServerType", "IP", "Port", "Matched",
+	"RuleID", "RuleName", "Severity", "Tags",
+	"Data", "Offset", "Before", "After",
+	"Error", "Stage",
+}
+
+// CSVWriter Writes each Finding of the matches as a row of CSV, after a header row
+type CSVWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+// NewCSVWriter Create writer of CSV to w
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{writer: csv.NewWriter(w)}
+}
+
+// Write Write the findings of a match
+func (c *CSVWriter) Write(match *serverpatdown.Match) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	for _, finding := range Findings(match) {
+		severity := ""
+		if finding.Severity != serverpatdown.SeverityUnknown {
+			severity = finding.Severity.String()
+		}
+		offset := ""
+		if finding.Data != "" {
+			offset = strconv.FormatInt(finding.Offset, 10)
+		}
+		err := c.writer.Write([]string{
+			finding.ConnectString, finding.ServerType, finding.IP, formatPort(finding.Port), strconv.FormatBool(finding.Matched),
+			finding.RuleID, finding.RuleName, severity, strings.Join(finding.Tags, ";"),
+			finding.Data, offset, finding.Before, finding.After,
+			finding.Error, finding.Stage,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// Close Write the header if nothing was written, and flush
+func (c *CSVWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// writeHeader Write the header row if it is not written yet
+func (c *CSVWriter) writeHeader() error {
+	if c.headerWritten {
+		return nil
+	}
+	c.headerWritten = true
+	return c.writer.Write(CSVHeader)
+}
+
+// formatPort Get port as text, empty for no port
+func formatPort(port uint16) string {
+	if port == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(port))
+}
@@ -0,0 +1,35 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/vertoforce/serverpatdown"
+)
+
+// JSONLinesWriter Writes each Finding of the matches as JSON on its own line
+type JSONLinesWriter struct {
+	encoder *json.Encoder
+}
+
+// NewJSONLinesWriter Create writer of JSON Lines to w
+func NewJSONLinesWriter(w io.Writer) *JSONLinesWriter {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &JSONLinesWriter{encoder: encoder}
+}
+
+// Write Write the findings of a match
+func (j *JSONLinesWriter) Write(match *serverpatdown.Match) error {
+	for _, finding := range Findings(match) {
+		if err := j.encoder.Encode(finding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close Nothing to finish for JSON Lines
+func (j *JSONLinesWriter) Close() error {
+	return nil
+}
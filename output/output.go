@@ -0,0 +1,125 @@
+// Package output Write the matches of serverpatdown.Searcher.Process as JSON Lines, CSV, or SARIF
+package output
+
+import (
+	"context"
+	"net"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/serverpatdown"
+	"github.com/vertoforce/serverpatdown/serverreaders"
+)
+
+// Finding Serializable result of searching a server, for one rule hit.
+// A server that matched has a finding for each rule hit, and a server that did not match (or a ServerReader error) has one finding without a rule
+type Finding struct {
+	ConnectString string `json:",omitempty"`
+	ServerType    string `json:",omitempty"`
+	IP            string `json:",omitempty"` // Ip in the connection string, or resolved by a Scanner, hostnames are not resolved
+	Port          uint16 `json:",omitempty"`
+	Matched       bool
+
+	RuleID   string                 `json:",omitempty"`
+	RuleName string                 `json:",omitempty"`
+	Severity serverpatdown.Severity `json:",omitempty"`
+	Tags     []string               `json:",omitempty"`
+
+	// Matched data, when Searcher.GetMatchedData is set
+	Data   string `json:",omitempty"`
+	Offset int64
+	Before string `json:",omitempty"`
+	After  string `json:",omitempty"`
+
+	Error string `json:",omitempty"`
+	Stage string `json:",omitempty"` // Stage the error occurred at, see serverpatdown.FailureStage
+}
+
+// Findings Get the findings of a match
+func Findings(match *serverpatdown.Match) []Finding {
+	base := Finding{Matched: match.Matched}
+	if match.Server != nil {
+		base.ConnectString = match.Server.GetConnectString()
+		base.ServerType = match.Server.Type().String()
+		base.IP = serverIP(match.Server)
+		base.Port = match.Server.GetPort()
+	}
+	if match.Err != nil {
+		base.Error = match.Err.Error()
+		base.Stage = match.Stage.String()
+	}
+
+	findings := []Finding{}
+	if len(match.Matches) > 0 {
+		for _, ruleMatch := range match.Matches {
+			finding := withRule(base, ruleMatch.Rule)
+			finding.Data = string(ruleMatch.Data)
+			finding.Offset = ruleMatch.Offset
+			finding.Before = string(ruleMatch.Before)
+			finding.After = string(ruleMatch.After)
+			findings = append(findings, finding)
+		}
+	} else {
+		for _, rule := range match.Rules {
+			findings = append(findings, withRule(base, rule))
+		}
+	}
+	if len(findings) == 0 {
+		findings = append(findings, base)
+	}
+	return findings
+}
+
+// serverIP Get the ip of a server without resolving its hostname, empty if it is not known
+func serverIP(server genericenricher.Server) string {
+	if hostServer, ok := server.(*serverreaders.HostServer); ok && len(hostServer.IP) > 0 {
+		return hostServer.IP.String()
+	}
+	if ip := net.ParseIP(serverreaders.ConnectStringHost(server.GetConnectString())); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// withRule Get copy of finding for a rule
+func withRule(finding Finding, rule *serverpatdown.Rule) Finding {
+	finding.RuleID = rule.ID
+	finding.RuleName = rule.Name
+	finding.Severity = rule.Severity
+	finding.Tags = rule.Tags
+	return finding
+}
+
+// Writer Writes matches in some format
+type Writer interface {
+	Write(match *serverpatdown.Match) error
+	// Close Finish writing.  This does not close the underlying io.Writer
+	Close() error
+}
+
+// WriteAll Write each match from matches until it is closed or ctx is canceled, then close the writer.
+// Matches are still read after a write fails so the Searcher is not held up, and the first error is returned
+func WriteAll(ctx context.Context, writer Writer, matches <-chan *serverpatdown.Match) error {
+	var firstErr error
+	for {
+		select {
+		case match, ok := <-matches:
+			if !ok {
+				if err := writer.Close(); err != nil && firstErr == nil {
+					firstErr = err
+				}
+				return firstErr
+			}
+			if firstErr == nil {
+				firstErr = writer.Write(match)
+			}
+		case <-ctx.Done():
+			if err := writer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if firstErr == nil {
+				firstErr = ctx.Err()
+			}
+			return firstErr
+		}
+	}
+}
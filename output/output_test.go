@@ -0,0 +1,216 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+	"github.com/vertoforce/serverpatdown"
+	"github.com/vertoforce/serverpatdown/serverreaders"
+)
+
+// fakeServer Server that is never connected to
+type fakeServer struct {
+	ip   net.IP
+	port uint16
+}
+
+func (f *fakeServer) GetIP() net.IP   { return f.ip }
+func (f *fakeServer) GetPort() uint16 { return f.port }
+func (f *fakeServer) GetConnectString() string {
+	return "http://" + net.JoinHostPort(f.ip.String(), formatPort(f.port))
+}
+func (f *fakeServer) IsConnected() bool             { return false }
+func (f *fakeServer) Type() enrichers.ServerType    { return enrichers.ELK }
+func (f *fakeServer) Close() error                  { return nil }
+func (f *fakeServer) Connect(context.Context) error { return nil }
+func (f *fakeServer) Read(p []byte) (int, error)    { return 0, errors.New("not connected") }
+func (f *fakeServer) ResetReader() error            { return nil }
+
+var (
+	passwordRule = &serverpatdown.Rule{
+		ID: "password", Name: "Password", Description: "Password in the data", Severity: serverpatdown.SeverityHigh,
+		Tags: []string{"credentials", "secret"}, Regex: regexp.MustCompile(`password=\w+`),
+	}
+	emailRule = &serverpatdown.Rule{ID: "email", Name: "Email", Regex: regexp.MustCompile(`\w+@\w+\.com`)}
+)
+
+// testMatches Matches with matched data, without matched data, not matched, and a reader error
+func testMatches() []*serverpatdown.Match {
+	server := &fakeServer{ip: net.IP{10, 0, 0, 1}, port: 9200}
+	return []*serverpatdown.Match{
+		{Matched: true, Server: server, Rules: []*serverpatdown.Rule{passwordRule, emailRule}, Matches: []serverpatdown.RuleMatch{
+			{Rule: passwordRule, Data: []byte("password=hunter2"), Offset: 10, Before: []byte("a,"), After: []byte(",b")},
+			{Rule: emailRule, Data: []byte("admin@example.com"), Offset: 40},
+			{Rule: passwordRule, Data: []byte("password=letmein"), Offset: 0},
+		}},
+		{Matched: true, Server: &fakeServer{ip: net.IP{10, 0, 0, 2}, port: 9200}, Rules: []*serverpatdown.Rule{emailRule}},
+		{Server: &fakeServer{ip: net.IP{10, 0, 0, 3}, port: 9200}, Err: errors.New("connection refused"), Stage: serverpatdown.ConnectFailure},
+		{Err: errors.New("quota exhausted"), Stage: serverpatdown.ReaderFailure},
+	}
+}
+
+func TestFindings(t *testing.T) {
+	matches := testMatches()
+
+	findings := Findings(matches[0])
+	if len(findings) != 3 {
+		t.Fatalf("Expected a finding for each rule hit, got %d", len(findings))
+	}
+	expected := Finding{
+		ConnectString: "http://10.0.0.1:9200", ServerType: "ELK", IP: "10.0.0.1", Port: 9200, Matched: true,
+		RuleID: "password", RuleName: "Password", Severity: serverpatdown.SeverityHigh, Tags: []string{"credentials", "secret"},
+		Data: "password=hunter2", Offset: 10, Before: "a,", After: ",b",
+	}
+	if !reflect.DeepEqual(findings[0], expected) {
+		t.Errorf("Expected %+v, got %+v", expected, findings[0])
+	}
+
+	// Without matched data there is a finding for each rule
+	findings = Findings(matches[1])
+	if len(findings) != 1 || findings[0].RuleID != "email" || findings[0].Data != "" {
+		t.Errorf("Wrong findings without matched data: %+v", findings)
+	}
+
+	// Not matched and reader errors have a finding without a rule
+	findings = Findings(matches[2])
+	if len(findings) != 1 || findings[0].RuleID != "" || findings[0].Error != "connection refused" || findings[0].Stage != "connect" {
+		t.Errorf("Wrong findings of failure: %+v", findings)
+	}
+	findings = Findings(matches[3])
+	if len(findings) != 1 || findings[0].ConnectString != "" || findings[0].Stage != "reader" {
+		t.Errorf("Wrong findings of reader failure: %+v", findings)
+	}
+}
+
+// writeMatches Write test matches with WriteAll
+func TestFindingsIP(t *testing.T) {
+	ipServer, err := genericenricher.GetServerWithType("http://10.0.0.1:9200", enrichers.ELK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostnameServer, err := genericenricher.GetServerWithType("http://nonexistent.invalid:9200", enrichers.ELK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostServer := &serverreaders.HostServer{Server: hostnameServer, Host: "nonexistent.invalid", IP: net.IP{10, 0, 0, 2}}
+
+	// The ip is taken from the connection string, or the ip a Scanner resolved, and a hostname is never resolved
+	expected := []struct {
+		server genericenricher.Server
+		ip     string
+	}{
+		{ipServer, "10.0.0.1"},
+		{hostnameServer, ""},
+		{hostServer, "10.0.0.2"},
+	}
+	for _, e := range expected {
+		findings := Findings(&serverpatdown.Match{Server: e.server})
+		if len(findings) != 1 || findings[0].IP != e.ip {
+			t.Errorf("Expected ip %q for %s, got %+v", e.ip, e.server.GetConnectString(), findings)
+		}
+	}
+}
+
+func writeMatches(t *testing.T, writer Writer) {
+	matches := make(chan *serverpatdown.Match)
+	go func() {
+		for _, match := range testMatches() {
+			matches <- match
+		}
+		close(matches)
+	}()
+	if err := WriteAll(context.Background(), writer, matches); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONLinesWriter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writeMatches(t, NewJSONLinesWriter(buffer))
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected 6 lines, got %d:\n%s", len(lines), buffer.String())
+	}
+	if lines[0] != `{"ConnectString":"http://10.0.0.1:9200","ServerType":"ELK","IP":"10.0.0.1","Port":9200,"Matched":true,`+
+		`"RuleID":"password","RuleName":"Password","Severity":"high","Tags":["credentials","secret"],`+
+		`"Data":"password=hunter2","Offset":10,"Before":"a,","After":",b"}` {
+		t.Errorf("Wrong JSON: %s", lines[0])
+	}
+	for _, line := range lines {
+		finding := Finding{}
+		if err := json.Unmarshal([]byte(line), &finding); err != nil {
+			t.Errorf("Invalid JSON %s: %v", line, err)
+		}
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writeMatches(t, NewCSVWriter(buffer))
+
+	records, err := csv.NewReader(buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 7 || !reflect.DeepEqual(records[0], CSVHeader) {
+		t.Fatalf("Expected header and 6 rows, got %v", records)
+	}
+	expected := []string{
+		"http://10.0.0.1:9200", "ELK", "10.0.0.1", "9200", "true",
+		"password", "Password", "high", "credentials;secret",
+		"password=hunter2", "10", "a,", ",b", "", "",
+	}
+	if !reflect.DeepEqual(records[1], expected) {
+		t.Errorf("Expected %v, got %v", expected, records[1])
+	}
+	if records[3][10] != "0" || records[4][10] != "" {
+		t.Errorf("Offset should only be set with matched data: %v %v", records[3], records[4])
+	}
+
+	// Header even without matches
+	buffer.Reset()
+	writer := NewCSVWriter(buffer)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != strings.Join(CSVHeader, ",")+"\n" {
+		t.Errorf("Expected only a header, got %q", buffer.String())
+	}
+}
+
+// failingWriter io.Writer that always fails
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestWriteAllError(t *testing.T) {
+	matches := make(chan *serverpatdown.Match)
+	go func() {
+		// Every match is still read after the error
+		for _, match := range testMatches() {
+			matches <- match
+		}
+		close(matches)
+	}()
+	if err := WriteAll(context.Background(), NewJSONLinesWriter(failingWriter{}), matches); err == nil || err.Error() != "disk full" {
+		t.Errorf("Expected write error, got %v", err)
+	}
+
+	// Stops when canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WriteAll(ctx, NewJSONLinesWriter(&bytes.Buffer{}), make(chan *serverpatdown.Match)); err != context.Canceled {
+		t.Errorf("Expected canceled, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/vertoforce/serverpatdown"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	toolName     = "serverpatdown"
+	toolURI      = "https://github.com/vertoforce/serverpatdown"
+)
+
+// SARIFWriter Writes the matches as a SARIF 2.1 log, with a result for each rule hit.
+// The location of each result is the connection string of the server, with the matched data when Searcher.GetMatchedData is set.
+// Errors are written as notifications of the run.  The log is written on Close
+type SARIFWriter struct {
+	w             io.Writer
+	rules         []sarifRule
+	ruleIndexes   map[string]int
+	results       []sarifResult
+	notifications []sarifNotification
+}
+
+// NewSARIFWriter Create writer of a SARIF log to w
+func NewSARIFWriter(w io.Writer) *SARIFWriter {
+	return &SARIFWriter{w: w, ruleIndexes: map[string]int{}, results: []sarifResult{}, notifications: []sarifNotification{}}
+}
+
+// Write Add the rule hits and errors of a match to the log
+func (s *SARIFWriter) Write(match *serverpatdown.Match) error {
+	if match.Err != nil {
+		target := "reader"
+		if match.Server != nil {
+			target = match.Server.GetConnectString()
+		}
+		s.notifications = append(s.notifications, sarifNotification{
+			Level:   "error",
+			Message: sarifMessage{Text: fmt.Sprintf("%s: %s failure: %v", target, match.Stage, match.Err)},
+		})
+	}
+	if !match.Matched {
+		return nil
+	}
+
+	for _, rule := range match.Rules {
+		s.addRule(rule)
+	}
+	for _, finding := range Findings(match) {
+		if finding.RuleID == "" {
+			continue
+		}
+		location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: finding.ConnectString}}}
+		message := fmt.Sprintf("%s matched %s", finding.ConnectString, finding.RuleName)
+		if finding.Data != "" {
+			location.PhysicalLocation.Region = &sarifRegion{
+				ByteOffset: finding.Offset,
+				ByteLength: int64(len(finding.Data)),
+				Snippet:    &sarifMessage{Text: finding.Data},
+			}
+			message = fmt.Sprintf("%s matched %s: %s", finding.ConnectString, finding.RuleName, finding.Data)
+		}
+		s.results = append(s.results, sarifResult{
+			RuleID:    finding.RuleID,
+			RuleIndex: s.ruleIndexes[finding.RuleID],
+			Level:     sarifLevel(finding.Severity),
+			Message:   sarifMessage{Text: message},
+			Locations: []sarifLocation{location},
+		})
+	}
+	return nil
+}
+
+// addRule Add a rule to the tool if it is not added yet
+func (s *SARIFWriter) addRule(rule *serverpatdown.Rule) {
+	if _, ok := s.ruleIndexes[rule.ID]; ok {
+		return
+	}
+	sRule := sarifRule{
+		ID:                   rule.ID,
+		Name:                 rule.Name,
+		ShortDescription:     sarifMessage{Text: rule.Name},
+		DefaultConfiguration: sarifConfiguration{Level: sarifLevel(rule.Severity)},
+	}
+	if rule.Description != "" {
+		sRule.FullDescription = &sarifMessage{Text: rule.Description}
+	}
+	if len(rule.Tags) > 0 {
+		sRule.Properties = &sarifProperties{Tags: rule.Tags}
+	}
+	s.ruleIndexes[rule.ID] = len(s.rules)
+	s.rules = append(s.rules, sRule)
+}
+
+// Close Write the log
+func (s *SARIFWriter) Close() error {
+	rules := s.rules
+	if rules == nil {
+		rules = []sarifRule{}
+	}
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: toolName, InformationURI: toolURI, Rules: rules}},
+			Results: s.results,
+			Invocations: []sarifInvocation{{
+				ExecutionSuccessful:        true,
+				ToolExecutionNotifications: s.notifications,
+			}},
+		}},
+	}
+	encoder := json.NewEncoder(s.w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+// sarifLevel Get SARIF level of a severity
+func sarifLevel(severity serverpatdown.Severity) string {
+	switch severity {
+	case serverpatdown.SeverityCritical, serverpatdown.SeverityHigh:
+		return "error"
+	case serverpatdown.SeverityLow, serverpatdown.SeverityInfo:
+		return "note"
+	default:
+		return "warning"
+	}
+}
+
+// SARIF 2.1 log, with only the properties we use
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Results     []sarifResult     `json:"results"`
+	Invocations []sarifInvocation `json:"invocations"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name,omitempty"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      *sarifMessage      `json:"fullDescription,omitempty"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           *sarifProperties   `json:"properties,omitempty"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifProperties struct {
+	Tags []string `json:"tags"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	ByteOffset int64         `json:"byteOffset"`
+	ByteLength int64         `json:"byteLength"`
+	Snippet    *sarifMessage `json:"snippet,omitempty"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                `json:"executionSuccessful"`
+	ToolExecutionNotifications []sarifNotification `json:"toolExecutionNotifications"`
+}
+
+type sarifNotification struct {
+	Level   string       `json:"level"`
+	Message sarifMessage `json:"message"`
+}
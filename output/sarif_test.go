@@ -0,0 +1,68 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSARIFWriter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writeMatches(t, NewSARIFWriter(buffer))
+
+	log := sarifLog{}
+	if err := json.Unmarshal(buffer.Bytes(), &log); err != nil {
+		t.Fatalf("Invalid SARIF %v:\n%s", err, buffer.String())
+	}
+	if log.Version != "2.1.0" || log.Schema == "" || len(log.Runs) != 1 {
+		t.Fatalf("Wrong SARIF log: %+v", log)
+	}
+	run := log.Runs[0]
+
+	// Each rule once, in the order first seen
+	if len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[0].ID != "password" || run.Tool.Driver.Rules[1].ID != "email" {
+		t.Errorf("Wrong rules: %+v", run.Tool.Driver.Rules)
+	}
+	rule := run.Tool.Driver.Rules[0]
+	if rule.DefaultConfiguration.Level != "error" || rule.FullDescription == nil || rule.FullDescription.Text != "Password in the data" ||
+		rule.Properties == nil || len(rule.Properties.Tags) != 2 {
+		t.Errorf("Wrong rule: %+v", rule)
+	}
+
+	// A result for each rule hit
+	if len(run.Results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(run.Results))
+	}
+	result := run.Results[0]
+	location := result.Locations[0].PhysicalLocation
+	if result.RuleID != "password" || result.RuleIndex != 0 || result.Level != "error" ||
+		location.ArtifactLocation.URI != "http://10.0.0.1:9200" || location.Region == nil ||
+		location.Region.ByteOffset != 10 || location.Region.ByteLength != 16 || location.Region.Snippet.Text != "password=hunter2" {
+		t.Errorf("Wrong result: %+v", result)
+	}
+	result = run.Results[3]
+	if result.RuleID != "email" || result.RuleIndex != 1 || result.Level != "warning" ||
+		result.Locations[0].PhysicalLocation.ArtifactLocation.URI != "http://10.0.0.2:9200" || result.Locations[0].PhysicalLocation.Region != nil {
+		t.Errorf("Wrong result without matched data: %+v", result)
+	}
+
+	// Errors are notifications
+	if len(run.Invocations) != 1 || len(run.Invocations[0].ToolExecutionNotifications) != 2 {
+		t.Errorf("Wrong invocations: %+v", run.Invocations)
+	}
+}
+
+func TestSARIFWriterEmpty(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	if err := NewSARIFWriter(buffer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	log := map[string]interface{}{}
+	if err := json.Unmarshal(buffer.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	run := log["runs"].([]interface{})[0].(map[string]interface{})
+	if results, ok := run["results"].([]interface{}); !ok || len(results) != 0 {
+		t.Errorf("Results should be an empty list: %s", buffer.String())
+	}
+}
@@ -0,0 +1,297 @@
+package serverpatdown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+	"github.com/vertoforce/serverpatdown/serverreaders"
+	"gopkg.in/yaml.v3"
+)
+
+// RulePack Set of rules loaded from a YAML or JSON file.
+//
+// Example YAML rule pack:
+//
+//	name: secrets
+//	include:
+//	  - common.yaml # Relative to this file
+//	disable:
+//	  - generic-password # Rule from any pack that is loaded, such as an include of another include
+//	rules:
+//	  - id: aws-access-key
+//	    name: AWS access key
+//	    description: Access key ID of an AWS account
+//	    severity: critical
+//	    tags: [aws, secret]
+//	    server_types: [http, elk] # Optional, defaults to all servers
+//	    pattern: 'AKIA[0-9A-Z]{16}'
+//	    enabled: true # Optional, defaults to true
+type RulePack struct {
+	Name  string
+	File  string
+	Rules []*Rule // Enabled rules of this pack and its includes, without any rule disabled by one of them
+}
+
+// RulePackError Error in a rule pack file
+type RulePackError struct {
+	File string
+	Line int // 0 if the error is not on a specific line
+	Err  error
+}
+
+func (e *RulePackError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %v", e.File, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// ruleLocation Where a rule was defined, for reporting duplicates
+type ruleLocation struct {
+	file string
+	line int
+}
+
+// rulePackLoader Loads a rule pack and its includes
+type rulePackLoader struct {
+	loading map[string]bool // Files currently being loaded, to detect include cycles
+	loaded  map[string]bool // Files already loaded, each file is only included once
+	rules   map[string]ruleLocation
+	disable []disabledRule // Checked and removed once every pack is loaded
+}
+
+// disabledRule Rule disabled by a pack, and where
+type disabledRule struct {
+	id   string
+	file string
+	line int
+}
+
+// LoadRulePack Load a rule pack file and all packs it includes.
+// Files ending in .json are read as JSON, everything else as YAML
+func LoadRulePack(filename string) (*RulePack, error) {
+	loader := &rulePackLoader{loading: map[string]bool{}, loaded: map[string]bool{}, rules: map[string]ruleLocation{}}
+	pack, err := loader.load(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// Disable rules from the whole pack, as a pack can disable a rule of a pack it did not include
+	disabled := map[string]bool{}
+	for _, rule := range loader.disable {
+		if _, ok := loader.rules[rule.id]; !ok {
+			return nil, &RulePackError{File: rule.file, Line: rule.line, Err: fmt.Errorf("cannot disable unknown rule `%s`", rule.id)}
+		}
+		disabled[rule.id] = true
+	}
+	rules := []*Rule{}
+	for _, rule := range pack.Rules {
+		if !disabled[rule.ID] {
+			rules = append(rules, rule)
+		}
+	}
+	pack.Rules = rules
+	return pack, nil
+}
+
+// AddRulePackFromFile Add all enabled rules from a rule pack file, see LoadRulePack
+func (searcher *Searcher) AddRulePackFromFile(filename string) error {
+	pack, err := LoadRulePack(filename)
+	if err != nil {
+		return err
+	}
+	for _, rule := range pack.Rules {
+		if err := searcher.AddRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (loader *rulePackLoader) load(filename string) (*RulePack, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if loader.loading[path] {
+		return nil, &RulePackError{File: filename, Err: errors.New("include cycle")}
+	}
+	pack := &RulePack{File: filename}
+	if loader.loaded[path] {
+		return pack, nil
+	}
+	loader.loading[path] = true
+	defer delete(loader.loading, path)
+	loader.loaded[path] = true
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, &RulePackError{File: filename, Err: err}
+	}
+
+	// JSON is valid YAML, but check it is actually JSON so mistakes are caught
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			line := 0
+			if syntaxErr, ok := err.(*json.SyntaxError); ok {
+				line = 1 + strings.Count(string(data[:syntaxErr.Offset]), "\n")
+			}
+			return nil, &RulePackError{File: filename, Line: line, Err: err}
+		}
+	}
+
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(data, document); err != nil {
+		return nil, &RulePackError{File: filename, Err: err}
+	}
+	if len(document.Content) == 0 {
+		// Empty file
+		return pack, nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, &RulePackError{File: filename, Line: root.Line, Err: errors.New("rule pack should be a map")}
+	}
+
+	// Read top level fields
+	var includes, disable []string
+	var disableNode, rulesNode *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "name":
+			err = value.Decode(&pack.Name)
+		case "include":
+			err = value.Decode(&includes)
+		case "disable":
+			err = value.Decode(&disable)
+			disableNode = value
+		case "rules":
+			if value.Kind != yaml.SequenceNode {
+				err = errors.New("rules should be a list")
+			}
+			rulesNode = value
+		default:
+			err = fmt.Errorf("unknown field `%s`", key.Value)
+		}
+		if err != nil {
+			return nil, &RulePackError{File: filename, Line: key.Line, Err: err}
+		}
+	}
+
+	// Load includes
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filename), include)
+		}
+		included, err := loader.load(include)
+		if err != nil {
+			return nil, err
+		}
+		pack.Rules = append(pack.Rules, included.Rules...)
+	}
+
+	// Load rules
+	if rulesNode != nil {
+		for _, ruleNode := range rulesNode.Content {
+			rule, enabled, err := loader.parseRule(filename, ruleNode)
+			if err != nil {
+				return nil, err
+			}
+			if enabled {
+				pack.Rules = append(pack.Rules, rule)
+			}
+		}
+	}
+
+	// Rules are disabled once every pack is loaded
+	for i, id := range disable {
+		loader.disable = append(loader.disable, disabledRule{id: id, file: filename, line: disableNode.Content[i].Line})
+	}
+
+	return pack, nil
+}
+
+// parseRule Parse a single rule, returns if the rule is enabled
+func (loader *rulePackLoader) parseRule(filename string, node *yaml.Node) (*Rule, bool, error) {
+	fail := func(line int, err error) (*Rule, bool, error) {
+		return nil, false, &RulePackError{File: filename, Line: line, Err: err}
+	}
+	if node.Kind != yaml.MappingNode {
+		return fail(node.Line, errors.New("rule should be a map"))
+	}
+
+	rule := &Rule{}
+	enabled := true
+	var pattern string
+	patternLine := node.Line
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "id":
+			err = value.Decode(&rule.ID)
+		case "name":
+			err = value.Decode(&rule.Name)
+		case "description":
+			err = value.Decode(&rule.Description)
+		case "severity":
+			var severity string
+			if err = value.Decode(&severity); err == nil {
+				rule.Severity, err = ParseSeverity(severity)
+			}
+		case "tags":
+			err = value.Decode(&rule.Tags)
+		case "server_types":
+			var serverTypes []string
+			err = value.Decode(&serverTypes)
+			for _, name := range serverTypes {
+				var serverType enrichers.ServerType
+				serverType, err = serverreaders.ParseServerType(name)
+				if err != nil {
+					break
+				}
+				rule.ServerTypes = append(rule.ServerTypes, serverType)
+			}
+		case "pattern":
+			err = value.Decode(&pattern)
+			patternLine = value.Line
+		case "enabled":
+			err = value.Decode(&enabled)
+		default:
+			err = fmt.Errorf("unknown rule field `%s`", key.Value)
+		}
+		if err != nil {
+			return fail(key.Line, err)
+		}
+	}
+
+	// Validate
+	if rule.ID == "" {
+		return fail(node.Line, errors.New("rule has no id"))
+	}
+	if previous, ok := loader.rules[rule.ID]; ok {
+		return fail(node.Line, fmt.Errorf("duplicate rule id `%s`, first defined at %s:%d", rule.ID, previous.file, previous.line))
+	}
+	loader.rules[rule.ID] = ruleLocation{file: filename, line: node.Line}
+	if pattern == "" {
+		return fail(node.Line, fmt.Errorf("rule `%s` has no pattern", rule.ID))
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fail(patternLine, fmt.Errorf("invalid regex: `%s`", pattern))
+	}
+	rule.Regex = regex
+	if rule.Name == "" {
+		rule.Name = rule.ID
+	}
+
+	return rule, enabled, nil
+}
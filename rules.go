@@ -0,0 +1,207 @@
+package serverpatdown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+	"github.com/vertoforce/multiregex"
+)
+
+// Severity How important a match of a rule is
+type Severity int
+
+// Severities from least to most important
+const (
+	SeverityUnknown Severity = iota
+	SeverityInfo
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"unknown", "info", "low", "medium", "high", "critical"}
+
+func (severity Severity) String() string {
+	if severity < 0 || int(severity) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(severity))
+	}
+	return severityNames[severity]
+}
+
+// ParseSeverity Get severity from its name, such as "critical"
+func ParseSeverity(name string) (Severity, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return SeverityUnknown, nil
+	}
+	for i, severityName := range severityNames {
+		if name == severityName {
+			return Severity(i), nil
+		}
+	}
+	return SeverityUnknown, fmt.Errorf("unknown severity `%s`", name)
+}
+
+// MarshalText Encode severity as its name
+func (severity Severity) MarshalText() ([]byte, error) {
+	return []byte(severity.String()), nil
+}
+
+// UnmarshalText Decode severity from its name
+func (severity *Severity) UnmarshalText(text []byte) error {
+	s, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*severity = s
+	return nil
+}
+
+// Rule Search rule with information about what a match means
+type Rule struct {
+	ID          string
+	Name        string
+	Description string
+	Severity    Severity
+	Tags        []string
+	ServerTypes []enrichers.ServerType // Only search servers of these types, or all servers if empty
+	Regex       *regexp.Regexp
+}
+
+// ruleJSON Rule with the regex as a string
+type ruleJSON struct {
+	ID          string
+	Name        string                 `json:",omitempty"`
+	Description string                 `json:",omitempty"`
+	Severity    Severity               `json:",omitempty"`
+	Tags        []string               `json:",omitempty"`
+	ServerTypes []enrichers.ServerType `json:",omitempty"`
+	Pattern     string
+}
+
+// NewRule Create rule with just a regex.  The ID and name are the regex itself
+func NewRule(regex *regexp.Regexp) *Rule {
+	return &Rule{ID: regex.String(), Name: regex.String(), Regex: regex}
+}
+
+// AppliesTo Check if the rule should be used to search a server of this type
+func (rule *Rule) AppliesTo(serverType enrichers.ServerType) bool {
+	if len(rule.ServerTypes) == 0 {
+		return true
+	}
+	for _, t := range rule.ServerTypes {
+		if t == serverType {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTag Check if the rule has a tag
+func (rule *Rule) HasTag(tag string) bool {
+	for _, t := range rule.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *Rule) String() string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return rule.ID
+}
+
+// MarshalJSON Encode rule with the regex as a string
+func (rule *Rule) MarshalJSON() ([]byte, error) {
+	r := ruleJSON{
+		ID:          rule.ID,
+		Name:        rule.Name,
+		Description: rule.Description,
+		Severity:    rule.Severity,
+		Tags:        rule.Tags,
+		ServerTypes: rule.ServerTypes,
+	}
+	if rule.Regex != nil {
+		r.Pattern = rule.Regex.String()
+	}
+	return json.Marshal(r)
+}
+
+// UnmarshalJSON Decode rule and compile its regex
+func (rule *Rule) UnmarshalJSON(data []byte) error {
+	r := ruleJSON{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	regex, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid regex: `%s`", r.Pattern)
+	}
+	*rule = Rule{
+		ID:          r.ID,
+		Name:        r.Name,
+		Description: r.Description,
+		Severity:    r.Severity,
+		Tags:        r.Tags,
+		ServerTypes: r.ServerTypes,
+		Regex:       regex,
+	}
+	return nil
+}
+
+// RuleMatch Data matched by a rule
+type RuleMatch struct {
+	Rule   *Rule
+	Data   []byte
+	Offset int64  // Offset of the match in the data read from the server
+	Before []byte // Data before the match, up to Searcher.MatchContextBefore bytes
+	After  []byte // Data after the match, up to Searcher.MatchContextAfter bytes
+}
+
+// AddRule Add search rule
+func (searcher *Searcher) AddRule(rule *Rule) error {
+	if rule.Regex == nil {
+		return errors.New("rule has no regex")
+	}
+	searcher.rules = append(searcher.rules, rule)
+	return nil
+}
+
+// Rules Get all search rules
+func (searcher *Searcher) Rules() []*Rule {
+	return append([]*Rule{}, searcher.rules...)
+}
+
+// rulesFor Get the rules that apply to a server type
+func (searcher *Searcher) rulesFor(serverType enrichers.ServerType) []*Rule {
+	rules := []*Rule{}
+	regexes := map[*regexp.Regexp]bool{}
+	for _, rule := range searcher.rules {
+		if !rule.AppliesTo(serverType) || regexes[rule.Regex] {
+			// Not for this server, or already searching with this regex
+			continue
+		}
+		rules = append(rules, rule)
+		regexes[rule.Regex] = true
+	}
+	return rules
+}
+
+// ruleSet Get the regexes of the rules, and the rule of each regex
+func ruleSet(rules []*Rule) (multiregex.RuleSet, map[*regexp.Regexp]*Rule) {
+	regexes := multiregex.RuleSet{}
+	regexRules := map[*regexp.Regexp]*Rule{}
+	for _, rule := range rules {
+		regexes = append(regexes, rule.Regex)
+		regexRules[rule.Regex] = rule
+	}
+	return regexes, regexRules
+}
@@ -0,0 +1,106 @@
+package serverpatdown
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		name     string
+		severity Severity
+		err      bool
+	}{
+		{"critical", SeverityCritical, false},
+		{" High ", SeverityHigh, false},
+		{"", SeverityUnknown, false},
+		{"urgent", SeverityUnknown, true},
+	}
+	for _, test := range tests {
+		severity, err := ParseSeverity(test.name)
+		if severity != test.severity || (err != nil) != test.err {
+			t.Errorf("ParseSeverity(%q) = %s, %v", test.name, severity, err)
+		}
+	}
+}
+
+func TestRuleJSON(t *testing.T) {
+	rule := &Rule{
+		ID:          "aws-access-key",
+		Name:        "AWS access key",
+		Severity:    SeverityCritical,
+		Tags:        []string{"aws", "secret"},
+		ServerTypes: []enrichers.ServerType{enrichers.HTTP},
+		Regex:       regexp.MustCompile(`AKIA[0-9A-Z]{16}`),
+	}
+	data, err := json.Marshal(rule)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	decoded := &Rule{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if decoded.ID != rule.ID || decoded.Severity != rule.Severity || decoded.Regex.String() != rule.Regex.String() ||
+		!decoded.HasTag("secret") || !decoded.AppliesTo(enrichers.HTTP) || decoded.AppliesTo(enrichers.ELK) {
+		t.Errorf("Rule did not decode correctly: %+v", decoded)
+	}
+
+	if err := json.Unmarshal([]byte(`{"ID":"bad","Pattern":"("}`), decoded); err == nil {
+		t.Errorf("Should have failed on invalid regex")
+	}
+}
+
+func TestProcessRules(t *testing.T) {
+	searcher := NewSearcher()
+	searcher.GetMatchedData = true
+	critical := &Rule{ID: "key", Name: "Secret key", Severity: SeverityCritical, Regex: regexp.MustCompile(`key=\w+`)}
+	elkOnly := &Rule{ID: "index", ServerTypes: []enrichers.ServerType{enrichers.ELK}, Regex: regexp.MustCompile(`hostname`)}
+	searcher.AddRule(critical)
+	searcher.AddRule(elkOnly)
+	if err := searcher.AddRule(&Rule{ID: "empty"}); err == nil {
+		t.Errorf("Should not add rule without regex")
+	}
+	searcher.AddServer(&fakeServer{data: []byte("hostname key=abc")})
+
+	matches, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	count := 0
+	for match := range matches {
+		count++
+		// The ELK rule should not be used on an unknown server
+		if len(match.Rules) != 1 || match.Rules[0] != critical {
+			t.Errorf("Did not report matched rule")
+		}
+		if len(match.Matches) != 1 || match.Matches[0].Rule != critical || string(match.Matches[0].Data) != "key=abc" {
+			t.Errorf("Did not report matched data")
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 match, got %d", count)
+	}
+
+	// Check first rule is reported without matched data
+	searcher.GetMatchedData = false
+	matches, _ = searcher.Process(context.Background())
+	count = 0
+	for match := range matches {
+		count++
+		if len(match.Rules) != 1 || match.Rules[0] != critical || len(match.Matches) != 0 {
+			t.Errorf("Did not report first matched rule")
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 match, got %d", count)
+	}
+}
@@ -0,0 +1,97 @@
+package serverpatdown
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/serverpatdown/serverreaders"
+)
+
+// ErrOutOfScope Server IP is not in any network added with AddScope, so it was not connected to
+var ErrOutOfScope = errors.New("server is out of scope")
+
+// AddScope Only search servers with an IP in this network, and any other networks added.
+// Servers from any source outside the scope are never connected to, and return a Match with
+// Err ErrOutOfScope and Stage ScopeFailure when ReturnNotMatchedServers is set.
+//
+// A server with a hostname is resolved just before connecting, and is only in scope if every ip it resolves to is.
+// The server resolves it again when connecting, so DNS that changes in between can still send it elsewhere,
+// and HTTP and ELK servers follow redirects to any host, as the genericenricher client can't be changed
+func (searcher *Searcher) AddScope(n net.IPNet) {
+	searcher.scope = append(searcher.scope, n)
+}
+
+// AddScopeFromFile Add networks to the scope from a file with one network (CIDR notation) or IP per line
+func (searcher *Searcher) AddScopeFromFile(filename string) error {
+	nets, err := serverreaders.ReadIPNetsFromFile(filename)
+	if err != nil {
+		return err
+	}
+	searcher.scope = append(searcher.scope, nets...)
+	return nil
+}
+
+// Scope Get the networks servers must be in, empty if all servers are searched
+func (searcher *Searcher) Scope() []net.IPNet {
+	return append([]net.IPNet{}, searcher.scope...)
+}
+
+// InScope Check if an IP can be searched.  Every IP is in scope if no scope was added
+func (searcher *Searcher) InScope(ip net.IP) bool {
+	if len(searcher.scope) == 0 {
+		return true
+	}
+	if len(ip) == 0 {
+		return false
+	}
+	for _, n := range searcher.scope {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverInScope Check if a server can be connected to.  If the server has a hostname, every ip it resolves to must be in scope
+func (searcher *Searcher) serverInScope(ctx context.Context, server genericenricher.Server) bool {
+	if len(searcher.scope) == 0 {
+		return true
+	}
+
+	host := serverreaders.ConnectStringHost(server.GetConnectString())
+	if host == "" {
+		return searcher.InScope(server.GetIP())
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return searcher.InScope(ip)
+	}
+
+	// Resolve the hostname like the server will when connecting
+	resolver := searcher.scopeResolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	c, cancel := context.WithTimeout(ctx, searcher.ServerTimeout)
+	defer cancel()
+	addrs, err := resolver.LookupIPAddr(c, host)
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	for _, addr := range addrs {
+		if !searcher.InScope(addr.IP) {
+			return false
+		}
+	}
+	return true
+}
+
+// scopeStrings Get the scope in CIDR notation
+func (searcher *Searcher) scopeStrings() []string {
+	scope := []string{}
+	for _, n := range searcher.scope {
+		scope = append(scope, n.String())
+	}
+	return scope
+}
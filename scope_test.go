@@ -0,0 +1,146 @@
+package serverpatdown
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/vertoforce/genericenricher"
+)
+
+func TestScope(t *testing.T) {
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.ReturnNotMatchedServers = true
+	if err := searcher.AddScopeFromFile(filepath.Join("testdata", "scope.txt")); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if len(searcher.Scope()) != 2 {
+		t.Errorf("Expected 2 networks in scope, got %d", len(searcher.Scope()))
+	}
+
+	inScope := &fakeServer{data: []byte("secret"), ip: net.IP{10, 0, 0, 5}}
+	outOfScope := &fakeServer{data: []byte("secret"), ip: net.IP{10, 0, 1, 5}}
+	outOfScopeFromReader := &fakeServer{data: []byte("secret"), ip: net.IP{192, 168, 1, 11}}
+	searcher.AddServer(inScope)
+	searcher.AddServer(outOfScope)
+	searcher.AddServerReader(&serverListReader{servers: []genericenricher.Server{outOfScopeFromReader}})
+
+	matchedServers, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	matched, rejected := 0, 0
+	for match := range matchedServers {
+		if match.Matched {
+			matched++
+		}
+		if match.Stage == ScopeFailure {
+			if match.Err != ErrOutOfScope {
+				t.Errorf("Expected ErrOutOfScope, got %v", match.Err)
+			}
+			rejected++
+		}
+	}
+	if matched != 1 || rejected != 2 {
+		t.Errorf("Expected 1 match and 2 servers out of scope, got %d and %d", matched, rejected)
+	}
+	if inScope.connects != 1 || outOfScope.connects != 0 || outOfScopeFromReader.connects != 0 {
+		t.Errorf("Connected to a server out of scope")
+	}
+
+	// Scope is saved in the session
+	session, err := searcher.Session()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if len(session.Scope) != 2 || session.Scope[0] != "10.0.0.0/24" || session.Scope[1] != "192.168.1.10/32" {
+		t.Errorf("Scope not saved in session: %v", session.Scope)
+	}
+	resumed := NewSearcher()
+	if _, err := resumed.Resume(context.Background(), &Session{Scope: session.Scope}); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if !resumed.InScope(net.IP{10, 0, 0, 1}) || resumed.InScope(net.IP{10, 0, 1, 1}) {
+		t.Errorf("Scope not restored from session")
+	}
+
+	// A scope added before resuming is kept
+	resumed = NewSearcher()
+	resumed.AddScope(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(25, 32)})
+	if _, err := resumed.Resume(context.Background(), &Session{Scope: session.Scope}); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if resumed.InScope(net.IP{10, 0, 0, 200}) {
+		t.Errorf("Session widened the scope")
+	}
+}
+
+func TestInScope(t *testing.T) {
+	searcher := NewSearcher()
+	if !searcher.InScope(net.IP{8, 8, 8, 8}) {
+		t.Errorf("Everything should be in scope without a scope")
+	}
+	_, v6, _ := net.ParseCIDR("2001:db8::/32")
+	searcher.AddScope(*v6)
+	if !searcher.InScope(net.ParseIP("2001:db8::1")) || searcher.InScope(net.ParseIP("2001:db9::1")) || searcher.InScope(nil) {
+		t.Errorf("Incorrect scope check")
+	}
+}
+
+// mapResolver Resolver of hostnames in a map
+type mapResolver map[string][]net.IP
+
+func (m mapResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := m[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := []net.IPAddr{}
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	}
+	return addrs, nil
+}
+
+func TestScopeHostnames(t *testing.T) {
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.ReturnNotMatchedServers = true
+	searcher.AddScope(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(24, 32)})
+	searcher.scopeResolver = mapResolver{
+		"inside.test": {{10, 0, 0, 6}, {10, 0, 0, 7}},
+		"split.test":  {{10, 0, 0, 5}, {10, 0, 1, 5}},
+	}
+
+	// The ip of split.test is the first one it resolves to, which is in scope, but it could connect to the other
+	inside := &fakeServer{data: []byte("secret"), ip: net.IP{10, 0, 0, 6}, host: "inside.test"}
+	split := &fakeServer{data: []byte("secret"), ip: net.IP{10, 0, 0, 5}, host: "split.test"}
+	unresolved := &fakeServer{data: []byte("secret"), ip: net.IP{10, 0, 0, 8}, host: "missing.test"}
+	searcher.AddServerReader(&serverListReader{servers: []genericenricher.Server{inside, split, unresolved}})
+
+	matchedServers, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	for match := range matchedServers {
+		server := match.Server.(*fakeServer)
+		if server == inside && !match.Matched {
+			t.Errorf("Did not search hostname in scope: %v", match.Err)
+		}
+		if server != inside && (match.Stage != ScopeFailure || match.Err != ErrOutOfScope) {
+			t.Errorf("Expected %s to be out of scope, got %v", server.host, match.Err)
+		}
+	}
+	if inside.connects != 1 || split.connects != 0 || unresolved.connects != 0 {
+		t.Errorf("Connected to a hostname out of scope")
+	}
+}
@@ -0,0 +1,476 @@
+package serverreaders
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/vertoforce/genericenricher"
+)
+
+// ServerReader Source of servers, the same as serverpatdown.ServerReader so the readers here can be added to a Searcher.
+// Every reader in this package is safe to use from multiple goroutines.  Close can be called during a ReadServer to stop
+// it, and the readers wrapping others pass Close on before waiting for their own reads
+type ServerReader interface {
+	ReadServer() (genericenricher.Server, error)
+	Close() error // Close server reader
+	Reset() error // Reset to start reading servers again
+}
+
+// StatefulReader Reader that can save and restore its position, the same as serverpatdown.StatefulServerReader.
+// Filter, Limit, Dedupe of one reader, and Except pass on the state of the reader they wrap, and have no state (nil)
+// if it has none.  Concat and Shuffle have no state, as the state of one reader can't say where they are
+type StatefulReader interface {
+	ServerReader
+	State() ([]byte, error)
+	SetState(state []byte) error
+}
+
+// KeyFunc Get the key of a server to find duplicates, such as ByIPPort
+type KeyFunc func(server genericenricher.Server) string
+
+// ByIPPort Servers are the same if they have the same IP and port.  The IP is taken from the connection string
+// without resolving hostnames, so servers with a hostname are the same if they have the same connection string
+func ByIPPort(server genericenricher.Server) string {
+	ip := net.ParseIP(ConnectStringHost(server.GetConnectString()))
+	if ip == nil {
+		return server.GetConnectString()
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(server.GetPort())))
+}
+
+// ByConnectString Servers are the same if they have the same connection string
+func ByConnectString(server genericenricher.Server) string {
+	return server.GetConnectString()
+}
+
+// ConcatReader Reads all servers of each reader in turn, see Concat
+type ConcatReader struct {
+	readers []ServerReader
+	lock    sync.Mutex // Held while reading
+	index   int
+}
+
+// Concat Create reader of all servers from each reader, in order
+func Concat(readers ...ServerReader) *ConcatReader {
+	return &ConcatReader{readers: readers}
+}
+
+// ReadServer Read the next server of the current reader, moving to the next reader at EOF
+func (c *ConcatReader) ReadServer() (genericenricher.Server, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for c.index < len(c.readers) {
+		server, err := c.readers[c.index].ReadServer()
+		if err == io.EOF {
+			c.index++
+			continue
+		}
+		return server, err
+	}
+	return nil, io.EOF
+}
+
+// Close Close every reader, returns the first error
+func (c *ConcatReader) Close() error {
+	err := closeAll(c.readers)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.index = len(c.readers)
+	return err
+}
+
+// Reset Reset every reader and start from the first
+func (c *ConcatReader) Reset() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.index = 0
+	return resetAll(c.readers)
+}
+
+// FilterReader Reads the servers of a reader that pass a check, see Filter
+type FilterReader struct {
+	reader ServerReader
+	keep   func(genericenricher.Server) bool
+}
+
+// Filter Create reader of the servers from reader that keep returns true for
+func Filter(reader ServerReader, keep func(genericenricher.Server) bool) *FilterReader {
+	return &FilterReader{reader: reader, keep: keep}
+}
+
+// ReadServer Read the next server to keep
+func (f *FilterReader) ReadServer() (genericenricher.Server, error) {
+	for {
+		server, err := f.reader.ReadServer()
+		if err != nil || server == nil || f.keep(server) {
+			return server, err
+		}
+	}
+}
+
+// Close Close the wrapped reader
+func (f *FilterReader) Close() error {
+	return f.reader.Close()
+}
+
+// Reset Reset the wrapped reader
+func (f *FilterReader) Reset() error {
+	return f.reader.Reset()
+}
+
+// State Get the state of the wrapped reader, nil if it has none
+func (f *FilterReader) State() ([]byte, error) {
+	return readerState(f.reader)
+}
+
+// SetState Restore the state of the wrapped reader
+func (f *FilterReader) SetState(state []byte) error {
+	return setReaderState(f.reader, state)
+}
+
+// LimitReader Reads up to a number of servers from a reader, see Limit
+type LimitReader struct {
+	reader ServerReader
+	limit  int
+	lock   sync.Mutex // Held while reading
+	read   int
+}
+
+// limitState State of a LimitReader
+type limitState struct {
+	Read   int
+	Reader []byte
+}
+
+// Limit Create reader of the first n servers from reader
+func Limit(reader ServerReader, n int) *LimitReader {
+	return &LimitReader{reader: reader, limit: n}
+}
+
+// ReadServer Read the next server, or EOF once n servers are read
+func (l *LimitReader) ReadServer() (genericenricher.Server, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.read >= l.limit {
+		return nil, io.EOF
+	}
+	server, err := l.reader.ReadServer()
+	if server != nil {
+		l.read++
+	}
+	return server, err
+}
+
+// Close Close the wrapped reader
+func (l *LimitReader) Close() error {
+	err := l.reader.Close()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.read = l.limit
+	return err
+}
+
+// Reset Reset the wrapped reader and the count of servers read
+func (l *LimitReader) Reset() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.read = 0
+	return l.reader.Reset()
+}
+
+// State Get the number of servers read and the state of the wrapped reader, nil if it has none
+func (l *LimitReader) State() ([]byte, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	state, err := readerState(l.reader)
+	if err != nil || state == nil {
+		return nil, err
+	}
+	return json.Marshal(limitState{Read: l.read, Reader: state})
+}
+
+// SetState Restore the number of servers read and the state of the wrapped reader
+func (l *LimitReader) SetState(state []byte) error {
+	if len(state) == 0 {
+		return setReaderState(l.reader, nil)
+	}
+	limit := limitState{}
+	if err := json.Unmarshal(state, &limit); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := setReaderState(l.reader, limit.Reader); err != nil {
+		return err
+	}
+	l.read = limit.Read
+	return nil
+}
+
+// DedupeReader Reads servers of readers skipping duplicates, see Dedupe
+type DedupeReader struct {
+	reader ServerReader
+	key    KeyFunc
+	lock   sync.Mutex // Held while reading
+	seen   map[string]bool
+}
+
+// Dedupe Create reader of servers from each reader in turn like Concat, skipping servers with the same key as one before.
+// Only a Dedupe of one reader has state, and it does not include the servers seen, so after SetState
+// servers seen before are not skipped
+func Dedupe(key KeyFunc, readers ...ServerReader) *DedupeReader {
+	var reader ServerReader
+	if len(readers) == 1 {
+		reader = readers[0]
+	} else {
+		reader = Concat(readers...)
+	}
+	return &DedupeReader{reader: reader, key: key, seen: map[string]bool{}}
+}
+
+// ReadServer Read the next server not seen before
+func (d *DedupeReader) ReadServer() (genericenricher.Server, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for {
+		server, err := d.reader.ReadServer()
+		if err != nil || server == nil {
+			return server, err
+		}
+		key := d.key(server)
+		if !d.seen[key] {
+			d.seen[key] = true
+			return server, nil
+		}
+	}
+}
+
+// Close Close the wrapped reader
+func (d *DedupeReader) Close() error {
+	return d.reader.Close()
+}
+
+// Reset Reset the wrapped reader and forget the servers seen
+func (d *DedupeReader) Reset() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.seen = map[string]bool{}
+	return d.reader.Reset()
+}
+
+// State Get the state of the wrapped reader, nil if it has none
+func (d *DedupeReader) State() ([]byte, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return readerState(d.reader)
+}
+
+// SetState Restore the state of the wrapped reader, forgetting the servers seen
+func (d *DedupeReader) SetState(state []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.seen = map[string]bool{}
+	return setReaderState(d.reader, state)
+}
+
+// ExceptReader Reads servers of a reader that are not in another reader, see Except
+type ExceptReader struct {
+	reader  ServerReader
+	except  ServerReader
+	key     KeyFunc
+	lock    sync.Mutex // Held while reading
+	exclude map[string]bool
+}
+
+// Except Create reader of servers from reader that are not in except, such as Shodan results
+// that are not in an inventory file.  All servers of except are read on the first read
+func Except(reader ServerReader, except ServerReader, key KeyFunc) *ExceptReader {
+	return &ExceptReader{reader: reader, except: except, key: key}
+}
+
+// ReadServer Read the next server not in except
+func (e *ExceptReader) ReadServer() (genericenricher.Server, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.exclude == nil {
+		if err := e.load(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		server, err := e.reader.ReadServer()
+		if err != nil || server == nil || !e.exclude[e.key(server)] {
+			return server, err
+		}
+	}
+}
+
+// load Read every server of except
+func (e *ExceptReader) load() error {
+	exclude := map[string]bool{}
+	for {
+		server, err := e.except.ReadServer()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if server != nil {
+			exclude[e.key(server)] = true
+		}
+	}
+	e.exclude = exclude
+	return nil
+}
+
+// Close Close both readers
+func (e *ExceptReader) Close() error {
+	return closeAll([]ServerReader{e.reader, e.except})
+}
+
+// Reset Reset both readers, except is read again on the next read
+func (e *ExceptReader) Reset() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.exclude = nil
+	return resetAll([]ServerReader{e.reader, e.except})
+}
+
+// State Get the state of reader, nil if it has none.  except is read again after SetState
+func (e *ExceptReader) State() ([]byte, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return readerState(e.reader)
+}
+
+// SetState Restore the state of reader, and reset except to read it again on the next read
+func (e *ExceptReader) SetState(state []byte) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.exclude = nil
+	if err := e.except.Reset(); err != nil {
+		return err
+	}
+	return setReaderState(e.reader, state)
+}
+
+// ShuffleReader Reads servers of a reader in random order using a buffer, see Shuffle
+type ShuffleReader struct {
+	reader ServerReader
+	size   int
+	seed   int64
+	lock   sync.Mutex // Held while reading
+	random *rand.Rand
+	buffer []genericenricher.Server
+	eof    bool
+}
+
+// Shuffle Create reader of servers from reader in random order, keeping up to size servers in a buffer.
+// Each server read is picked at random from the buffer, so servers move up to about size places.
+// The same seed gives the same order
+func Shuffle(reader ServerReader, size int, seed int64) *ShuffleReader {
+	if size < 1 {
+		size = 1
+	}
+	return &ShuffleReader{reader: reader, size: size, seed: seed, random: rand.New(rand.NewSource(seed))}
+}
+
+// ReadServer Fill the buffer and read a random server from it
+func (s *ShuffleReader) ReadServer() (genericenricher.Server, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for !s.eof && len(s.buffer) < s.size {
+		server, err := s.reader.ReadServer()
+		if err == io.EOF {
+			s.eof = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if server != nil {
+			s.buffer = append(s.buffer, server)
+		}
+	}
+	if len(s.buffer) == 0 {
+		return nil, io.EOF
+	}
+
+	i := s.random.Intn(len(s.buffer))
+	server := s.buffer[i]
+	s.buffer[i] = s.buffer[len(s.buffer)-1]
+	s.buffer = s.buffer[:len(s.buffer)-1]
+	return server, nil
+}
+
+// Close Close the wrapped reader, dropping the buffered servers
+func (s *ShuffleReader) Close() error {
+	err := s.reader.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buffer = nil
+	s.eof = true
+	return err
+}
+
+// Reset Reset the wrapped reader and the random order
+func (s *ShuffleReader) Reset() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buffer = nil
+	s.eof = false
+	s.random = rand.New(rand.NewSource(s.seed))
+	return s.reader.Reset()
+}
+
+// readerState Get the state of a reader, nil if it is not a StatefulReader
+func readerState(reader ServerReader) ([]byte, error) {
+	if stateful, ok := reader.(StatefulReader); ok {
+		return stateful.State()
+	}
+	return nil, nil
+}
+
+// setReaderState Restore the state of a reader from readerState
+func setReaderState(reader ServerReader, state []byte) error {
+	if stateful, ok := reader.(StatefulReader); ok {
+		return stateful.SetState(state)
+	}
+	if len(state) > 0 {
+		return errors.New("wrapped reader has no state to restore")
+	}
+	return nil
+}
+
+// closeAll Close each reader, returns the first error
+func closeAll(readers []ServerReader) error {
+	var first error
+	for _, reader := range readers {
+		if err := reader.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// resetAll Reset each reader, returns the first error
+func resetAll(readers []ServerReader) error {
+	var first error
+	for _, reader := range readers {
+		if err := reader.Reset(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
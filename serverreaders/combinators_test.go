@@ -0,0 +1,220 @@
+package serverreaders
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// listReader Reader of servers from a list of connection strings, counting closes and resets
+type listReader struct {
+	*FileReader
+	closes, resets int
+}
+
+func newListReader(servers ...string) *listReader {
+	return &listReader{FileReader: NewFileReader(strings.NewReader(strings.Join(servers, "\n") + "\n"))}
+}
+
+func (l *listReader) Close() error {
+	l.closes++
+	return l.FileReader.Close()
+}
+
+func (l *listReader) Reset() error {
+	l.resets++
+	return l.FileReader.Reset()
+}
+
+// readAll Read the connection strings of every server in a reader
+func readAll(t *testing.T, reader ServerReader) []string {
+	connectStrings := []string{}
+	for {
+		server, err := reader.ReadServer()
+		if err == io.EOF {
+			return connectStrings
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		connectStrings = append(connectStrings, server.GetConnectString())
+	}
+}
+
+// checkReadTwice Check a reader reads the expected servers, and again after a reset
+func checkReadTwice(t *testing.T, name string, reader ServerReader, expected []string) {
+	for i := 0; i < 2; i++ {
+		if got := readAll(t, reader); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+		if _, err := reader.ReadServer(); err != io.EOF {
+			t.Errorf("%s: should have been EOF", name)
+		}
+		if err := reader.Reset(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	a := newListReader("10.0.0.1:9200 elk", "10.0.0.2:21 ftp", "10.0.0.3:80 http")
+	b := newListReader("10.0.0.2:21 ftp", "10.0.0.4:9200 elk")
+
+	checkReadTwice(t, "concat", Concat(a, b), []string{
+		"http://10.0.0.1:9200", "ftp://10.0.0.2:21", "http://10.0.0.3:80", "ftp://10.0.0.2:21", "http://10.0.0.4:9200",
+	})
+	checkReadTwice(t, "filter", Filter(a, func(server genericenricher.Server) bool {
+		return server.GetPort() == 9200
+	}), []string{"http://10.0.0.1:9200"})
+	checkReadTwice(t, "limit", Limit(Concat(a, b), 4), []string{
+		"http://10.0.0.1:9200", "ftp://10.0.0.2:21", "http://10.0.0.3:80", "ftp://10.0.0.2:21",
+	})
+	checkReadTwice(t, "limit 0", Limit(a, 0), []string{})
+	checkReadTwice(t, "dedupe", Dedupe(ByIPPort, a, b), []string{
+		"http://10.0.0.1:9200", "ftp://10.0.0.2:21", "http://10.0.0.3:80", "http://10.0.0.4:9200",
+	})
+	checkReadTwice(t, "except", Except(a, b, ByConnectString), []string{
+		"http://10.0.0.1:9200", "http://10.0.0.3:80",
+	})
+
+	// Close and Reset reach every wrapped reader
+	a.closes, a.resets, b.closes, b.resets = 0, 0, 0, 0
+	reader := Shuffle(Dedupe(ByIPPort, Limit(Filter(Concat(a, b), func(genericenricher.Server) bool { return true }), 10)), 2, 1)
+	if err := reader.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if a.closes != 1 || a.resets != 1 || b.closes != 1 || b.resets != 1 {
+		t.Errorf("Did not propagate close and reset: %+v %+v", a, b)
+	}
+	if _, err := reader.ReadServer(); err != io.EOF {
+		t.Errorf("Should have been EOF after close")
+	}
+}
+
+func TestDedupeHostnames(t *testing.T) {
+	// Servers with a hostname are deduped by connection string, without resolving it
+	reader := Dedupe(ByIPPort, newListReader("nonexistent-a.invalid:9200 elk", "nonexistent-b.invalid:9200 elk",
+		"nonexistent-a.invalid:9200 elk", "[2001:db8::1]:9200 elk", "http://[2001:db8::1]:9200"))
+	checkReadTwice(t, "dedupe", reader, []string{"http://nonexistent-a.invalid:9200", "http://nonexistent-b.invalid:9200", "http://[2001:db8::1]:9200"})
+}
+
+func TestShuffle(t *testing.T) {
+	servers := []string{}
+	for i := 1; i <= 50; i++ {
+		servers = append(servers, fmt.Sprintf("10.0.0.%d:80 http", i))
+	}
+	expected := readAll(t, newListReader(servers...))
+
+	reader := Shuffle(newListReader(servers...), 8, 42)
+	first := readAll(t, reader)
+	if reflect.DeepEqual(first, expected) {
+		t.Errorf("Did not shuffle servers")
+	}
+	if len(first) != len(expected) {
+		t.Fatalf("Expected %d servers, got %d", len(expected), len(first))
+	}
+
+	// Every server read once, and never more than the buffer size early
+	position := map[string]int{}
+	for i, connectString := range expected {
+		position[connectString] = i
+	}
+	for i, connectString := range first {
+		p, ok := position[connectString]
+		if !ok {
+			t.Errorf("Unexpected server %s", connectString)
+		}
+		delete(position, connectString)
+		if p > i+8 {
+			t.Errorf("Server %s moved from %d to %d, more than the buffer", connectString, p, i)
+		}
+	}
+
+	// The same order after a reset
+	if err := reader.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if second := readAll(t, reader); !reflect.DeepEqual(first, second) {
+		t.Errorf("Order changed after reset")
+	}
+}
+
+// errorReader Reader that always fails
+type errorReader struct{ listReader }
+
+func (e *errorReader) ReadServer() (genericenricher.Server, error) {
+	return nil, errors.New("read failed")
+}
+
+func TestCombinatorErrors(t *testing.T) {
+	for name, reader := range map[string]ServerReader{
+		"concat":  Concat(newListReader(), &errorReader{}),
+		"filter":  Filter(&errorReader{}, func(genericenricher.Server) bool { return true }),
+		"limit":   Limit(&errorReader{}, 1),
+		"dedupe":  Dedupe(ByIPPort, &errorReader{}),
+		"except":  Except(newListReader("10.0.0.1:80 http"), &errorReader{}, ByIPPort),
+		"shuffle": Shuffle(&errorReader{}, 4, 1),
+	} {
+		if _, err := reader.ReadServer(); err == nil || err == io.EOF {
+			t.Errorf("%s: expected read error, got %v", name, err)
+		}
+	}
+}
+
+func TestCombinatorState(t *testing.T) {
+	newScanner := func() *Scanner {
+		scanner := NewScanner()
+		scanner.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(28, 32)})
+		scanner.AddPort(80)
+		scanner.SetServerType(enrichers.HTTP)
+		return scanner
+	}
+	odd := func(server genericenricher.Server) bool { return server.GetIP()[len(server.GetIP())-1]%2 == 1 }
+	newReader := func() StatefulReader {
+		return Except(Dedupe(ByIPPort, Limit(Filter(newScanner(), odd), 6)), newListReader("10.0.0.3:80 http"), ByIPPort)
+	}
+	expected := readAll(t, newReader())
+	if len(expected) != 5 {
+		t.Fatalf("Expected 5 servers, got %v", expected)
+	}
+
+	// Read some, then continue with a new reader from the state
+	reader := newReader()
+	first := []string{}
+	for i := 0; i < 2; i++ {
+		server, err := reader.ReadServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		first = append(first, server.GetConnectString())
+	}
+	state, err := reader.State()
+	if err != nil || state == nil {
+		t.Fatalf("Expected state, got %v", err)
+	}
+	reader = newReader()
+	if err := reader.SetState(state); err != nil {
+		t.Fatal(err)
+	}
+	if got := append(first, readAll(t, reader)...); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v after resuming, got %v", expected, got)
+	}
+
+	// No state without a stateful reader
+	if state, err := Limit(Filter(newListReader("10.0.0.1:80 http"), odd), 1).State(); state != nil || err != nil {
+		t.Errorf("Expected no state, got %s %v", state, err)
+	}
+	if err := Filter(newListReader(), odd).SetState([]byte("{}")); err == nil {
+		t.Errorf("Should not set state of reader without state")
+	}
+}
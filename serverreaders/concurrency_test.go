@@ -0,0 +1,257 @@
+package serverreaders
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// concurrentReaders Readers to check with many goroutines, and how many servers each reads
+func concurrentReaders(t *testing.T, shodanURL string) map[string]struct {
+	reader  ServerReader
+	servers int
+} {
+	scanner := NewScanner()
+	scanner.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(26, 32)})
+	scanner.Ports = []int{80, 443}
+	scanner.SetServerType(enrichers.HTTP)
+
+	probing := NewScanner()
+	probing.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(28, 32)})
+	probing.Ports = []int{80}
+	probing.SetServerType(enrichers.HTTP)
+	probing.CheckPortOpen = true
+	probing.ProbeWorkers = 4
+	probing.dial = (&fakeDialer{delay: time.Millisecond}).dial
+
+	shodanReader, err := NewShodanWithOptions(context.Background(), "test", "token", time.Second, ShodanOptions{BaseURL: shodanURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, err := NewShodanFromFile("testdata/shodan_export.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("10.1.0.%d", i))
+	}
+	zmap, err := NewZmapReader(strings.NewReader(strings.Join(lines, "\n")+"\n"), 9200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zmap.SetServerType(enrichers.ELK)
+
+	servers := func(from, to int) []string {
+		list := []string{}
+		for i := from; i < to; i++ {
+			list = append(list, fmt.Sprintf("10.2.0.%d:21 ftp", i))
+		}
+		return list
+	}
+
+	return map[string]struct {
+		reader  ServerReader
+		servers int
+	}{
+		"scanner":       {scanner, 128},
+		"probing":       {probing, 16},
+		"shodan":        {shodanReader, 250},
+		"shodan export": {export, 3},
+		"file":          {newListReader(servers(0, 100)...), 100},
+		"scan results":  {zmap, 100},
+		"combined":      {Shuffle(Dedupe(ByIPPort, newListReader(servers(0, 60)...), newListReader(servers(40, 100)...)), 8, 1), 100},
+		"limit":         {Limit(Filter(newListReader(servers(0, 100)...), func(genericenricher.Server) bool { return true }), 30), 30},
+		"except":        {Except(newListReader(servers(0, 100)...), newListReader(servers(0, 50)...), ByIPPort), 50},
+	}
+}
+
+// readConcurrently Read every server of reader with workers goroutines
+func readConcurrently(t *testing.T, reader ServerReader, workers int) map[string]int {
+	read := map[string]int{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				server, err := reader.ReadServer()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				lock.Lock()
+				read[server.GetConnectString()]++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return read
+}
+
+func TestConcurrentRead(t *testing.T) {
+	api, _ := newShodanAPI(250)
+	defer api.Close()
+
+	for name, test := range concurrentReaders(t, api.URL) {
+		for i := 0; i < 2; i++ {
+			read := readConcurrently(t, test.reader, 16)
+			if len(read) != test.servers {
+				t.Errorf("%s: expected %d servers, got %d", name, test.servers, len(read))
+			}
+			for connectString, count := range read {
+				if count != 1 {
+					t.Errorf("%s: read %s %d times", name, connectString, count)
+				}
+			}
+			if _, err := test.reader.ReadServer(); err != io.EOF {
+				t.Errorf("%s: should have been EOF", name)
+			}
+			if err := test.reader.Reset(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestConcurrentCloseReset(t *testing.T) {
+	api, _ := newShodanAPI(250)
+	defer api.Close()
+
+	for name, test := range concurrentReaders(t, api.URL) {
+		reader := test.reader
+		stop := make(chan struct{})
+		wg := sync.WaitGroup{}
+
+		// Read until stopped
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					reader.ReadServer()
+				}
+			}()
+		}
+
+		// Close, reset, and get the state while reading
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				reader.Close()
+				reader.Reset()
+				if stateful, ok := reader.(interface{ State() ([]byte, error) }); ok {
+					stateful.State()
+				}
+			}
+			close(stop)
+		}()
+
+		finished := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(time.Second * 10):
+			t.Fatalf("%s: deadlocked", name)
+		}
+
+		// Reads after close are EOF
+		if err := reader.Close(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if _, err := reader.ReadServer(); err != io.EOF {
+			t.Errorf("%s: should have been EOF after close, got %v", name, err)
+		}
+	}
+}
+
+func TestCloseDuringSlowRead(t *testing.T) {
+	// Second page of results takes a while
+	api, _ := newShodanAPI(200)
+	defer api.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second * 3):
+			}
+		}
+		proxied, err := http.Get(api.URL + r.URL.String())
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer proxied.Body.Close()
+		io.Copy(w, proxied.Body)
+	}))
+	defer slow.Close()
+
+	shodanReader, err := NewShodanWithOptions(context.Background(), "test", "token", time.Second*10, ShodanOptions{BaseURL: slow.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < shodanPageSize; i++ {
+		if _, err := shodanReader.ReadServer(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pipe that never has another line
+	pipeReader, pipeWriter := io.Pipe()
+	defer pipeWriter.Close()
+	fileReader := NewFileReader(pipeReader)
+
+	for name, reader := range map[string]ServerReader{"shodan": shodanReader, "file": fileReader} {
+		readErr := make(chan error, 1)
+		go func() {
+			_, err := reader.ReadServer()
+			readErr <- err
+		}()
+		time.Sleep(time.Millisecond * 100)
+
+		start := time.Now()
+		if err := reader.Close(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+			t.Errorf("%s: close took %s", name, elapsed)
+		}
+		if name == "file" {
+			// The pipe can't be interrupted, the read returns once it does
+			pipeWriter.Write([]byte("10.0.0.1:21 ftp\n"))
+		}
+		select {
+		case err := <-readErr:
+			if err != io.EOF {
+				t.Errorf("%s: read should have been EOF after close, got %v", name, err)
+			}
+		case <-time.After(time.Millisecond * 500):
+			t.Errorf("%s: read did not return after close", name)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package serverreaders
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ipRange Range of ips from first to last.  IPv4 addresses are in their IPv4-mapped IPv6 form
+type ipRange struct {
+	first, last *big.Int
+	v4          bool // Return ips as 4 byte IPv4 addresses
+}
+
+// netRange Get the range of ips in a network
+func netRange(n net.IPNet) ipRange {
+	ones, bits := n.Mask.Size()
+	first := ipToInt(n.IP.Mask(n.Mask))
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	last := new(big.Int).Sub(size.Add(size, first), big.NewInt(1))
+	return ipRange{first: first, last: last, v4: bits == 8*net.IPv4len}
+}
+
+// size Number of ips in the range
+func (r ipRange) size() *big.Int {
+	size := new(big.Int).Sub(r.last, r.first)
+	return size.Add(size, big.NewInt(1))
+}
+
+// sizeUint64 Number of ips in the range, or math.MaxUint64 if there are more
+func (r ipRange) sizeUint64() uint64 {
+	size := r.size()
+	if !size.IsUint64() {
+		return math.MaxUint64
+	}
+	return size.Uint64()
+}
+
+// ipAt Get the ip at index i of the range
+func (r ipRange) ipAt(i uint64) net.IP {
+	ip := intToIP(new(big.Int).Add(r.first, new(big.Int).SetUint64(i)))
+	if r.v4 {
+		return ip.To4()
+	}
+	return ip
+}
+
+// ipToInt Get ip as a number, in its 16 byte form
+func ipToInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+// intToIP Get 16 byte ip from a number
+func intToIP(n *big.Int) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	b := n.Bytes()
+	copy(ip[net.IPv6len-len(b):], b)
+	return ip
+}
+
+// ranges Get the ranges of ips to scan, which are Nets without the Exclusions
+func (s *Scanner) ranges() []ipRange {
+	// Sort and merge exclusions
+	exclusions := []ipRange{}
+	for _, n := range s.Exclusions {
+		exclusions = append(exclusions, netRange(n))
+	}
+	sort.Slice(exclusions, func(i, j int) bool {
+		return exclusions[i].first.Cmp(exclusions[j].first) < 0
+	})
+	merged := []ipRange{}
+	for _, e := range exclusions {
+		if len(merged) > 0 {
+			previous := &merged[len(merged)-1]
+			if e.first.Cmp(new(big.Int).Add(previous.last, big.NewInt(1))) <= 0 {
+				if e.last.Cmp(previous.last) > 0 {
+					previous.last = e.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, e)
+	}
+
+	// Remove exclusions from each network
+	ranges := []ipRange{}
+	for _, n := range s.Nets {
+		r := netRange(n)
+		for _, e := range merged {
+			if e.last.Cmp(r.first) < 0 {
+				// Before the range
+				continue
+			}
+			if e.first.Cmp(r.last) > 0 {
+				// After the range
+				break
+			}
+			if e.first.Cmp(r.first) > 0 {
+				ranges = append(ranges, ipRange{first: r.first, last: new(big.Int).Sub(e.first, big.NewInt(1)), v4: r.v4})
+			}
+			r.first = new(big.Int).Add(e.last, big.NewInt(1))
+			if r.first.Cmp(r.last) > 0 {
+				break
+			}
+		}
+		if r.first.Cmp(r.last) <= 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// AddExclusion Add network to skip while scanning, even if it is in Nets
+func (s *Scanner) AddExclusion(n net.IPNet) {
+	s.Exclusions = append(s.Exclusions, n)
+}
+
+// LoadExclusionsFromFile Add networks to skip from a file, see ReadIPNets
+func (s *Scanner) LoadExclusionsFromFile(filename string) error {
+	nets, err := ReadIPNetsFromFile(filename)
+	if err != nil {
+		return err
+	}
+	s.Exclusions = append(s.Exclusions, nets...)
+	return nil
+}
+
+// ParseIPNet Parse a network in CIDR notation, or a single ip
+func ParseIPNet(s string) (net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return net.IPNet{}, errors.New("invalid CIDR")
+		}
+		return *n, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return net.IPNet{}, errors.New("invalid ip")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ReadIPNets Read networks (CIDR notation) and single ips, one per line.
+// Blank lines and text after # are skipped.  Returns a *LineError for invalid lines
+func ReadIPNets(reader io.Reader) ([]net.IPNet, error) {
+	nets := []net.IPNet{}
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		n, err := ParseIPNet(text)
+		if err != nil {
+			return nil, &LineError{Line: line, Text: text, Err: err}
+		}
+		nets = append(nets, n)
+	}
+	return nets, scanner.Err()
+}
+
+// ReadIPNetsFromFile Read networks and single ips from a file, see ReadIPNets
+func ReadIPNetsFromFile(filename string) ([]net.IPNet, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadIPNets(file)
+}
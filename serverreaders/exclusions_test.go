@@ -0,0 +1,92 @@
+package serverreaders
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExclusions(t *testing.T) {
+	s := NewScanner()
+	s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(24, 32)})
+	s.AddPort(80)
+	if err := s.LoadExclusionsFromFile(filepath.Join("testdata", "exclusions.txt")); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	// Only 10.0.0.64-127 without 10.0.0.70 should be left
+	count := 0
+	for ip := range s.GetIPs(context.Background()) {
+		if ip[3] < 64 || ip[3] > 127 || ip[3] == 70 || len(ip) != net.IPv4len {
+			t.Errorf("Got excluded ip %s", ip)
+		}
+		count++
+	}
+	if count != 63 {
+		t.Errorf("Expected 63 ips, got %d", count)
+	}
+	plan, err := s.Plan()
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if plan.IPs.Int64() != 63 || plan.Excluded.Int64() != 193 {
+		t.Errorf("Incorrect plan: %s ips, %s excluded", plan.IPs, plan.Excluded)
+	}
+}
+
+func TestLargeExclusions(t *testing.T) {
+	_, v6, _ := net.ParseCIDR("2001:db8::/48")
+	_, v6Half, _ := net.ParseCIDR("2001:db8::/49")
+	_, v6Rest, _ := net.ParseCIDR("2001:db8:0:8000::/50")
+
+	// Excluded space should not be enumerated
+	s := NewScanner()
+	s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)})
+	s.AddIPNet(*v6)
+	s.AddExclusion(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(9, 32)})
+	s.AddExclusion(net.IPNet{IP: net.IP{10, 128, 0, 0}, Mask: net.CIDRMask(9, 32)})
+	s.AddExclusion(*v6Half)
+	s.AddExclusion(*v6Rest)
+	s.AddPort(80)
+
+	start := time.Now()
+	plan, _ := s.Plan()
+	expected := "302231454903657293676544" // 2^78, the last /50 of the /48
+	if plan.IPs.String() != expected {
+		t.Errorf("Expected %s ips, got %s", expected, plan.IPs)
+	}
+	ip := <-s.GetIPs(context.Background())
+	if ip.String() != "2001:db8:0:c000::" {
+		t.Errorf("First ip should be after the exclusions, got %s", ip)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Took too long to skip excluded ips")
+	}
+}
+
+func TestReadIPNets(t *testing.T) {
+	nets, err := ReadIPNets(strings.NewReader("10.0.0.0/8\n\n# comment\n::1\n192.168.1.1 # host\n"))
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	expected := []string{"10.0.0.0/8", "::1/128", "192.168.1.1/32"}
+	if len(nets) != len(expected) {
+		t.Errorf("Expected %d networks, got %d", len(expected), len(nets))
+		return
+	}
+	for i, n := range nets {
+		if n.String() != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], n.String())
+		}
+	}
+
+	_, err = ReadIPNets(strings.NewReader("10.0.0.0/8\n10.0.0.300\n"))
+	if lineErr, ok := err.(*LineError); !ok || lineErr.Line != 2 {
+		t.Errorf("Expected error on line 2, got %v", err)
+	}
+}
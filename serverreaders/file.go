@@ -0,0 +1,274 @@
+package serverreaders
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// LineError Malformed line in a list of servers
+type LineError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v: `%s`", e.Line, e.Err, e.Text)
+}
+
+// FileReader Reads servers from a list with one server per line.  Implements ServerReader
+//
+// Each line is a connection string or host:port, optionally followed by the type of server (https for HTTP over TLS).
+// The type of host:port lines without one is the type set with SetServerType, or else detected by probing the port,
+// and the line is malformed if it can't be.  Blank lines and lines starting with # are skipped.
+//
+//	# Inventory
+//	http://10.0.0.1:9200
+//	10.0.0.2:21 ftp
+//	10.0.0.3:443 https
+//	db.internal:9200 elk
+//
+// It is safe to use from multiple goroutines.  Close stops a read waiting on a file the reader opened,
+// but can't stop a read waiting on a reader passed to NewFileReader such as os.Stdin, which returns once the reader does
+type FileReader struct {
+	// Return a *LineError from ReadServer for malformed lines instead of skipping them
+	Strict bool
+	// Timeout of each connection when probing a port to detect the type of server
+	Timeout time.Duration
+
+	filename    string     // Set if we opened the file, so it can be opened again on Reset
+	readLock    sync.Mutex // Held while reading, protects the fields below
+	source      io.Reader
+	scanner     *bufio.Scanner
+	line        int
+	stateLock   sync.Mutex // Protects the fields below, never held while waiting for a line
+	file        *os.File   // File we opened
+	closed      bool
+	serverType  enrichers.ServerType
+	malformed   []*LineError
+	cancelProbe context.CancelFunc // Stops probing a port, set while probing
+}
+
+// NewFileReader Create reader of servers from a list, such as os.Stdin.
+// Reset only works if the reader is an io.Seeker
+func NewFileReader(reader io.Reader) *FileReader {
+	f := &FileReader{source: reader, Timeout: defaultPortScanTimeout}
+	f.scanner = bufio.NewScanner(reader)
+	return f
+}
+
+// NewFileReaderFromFile Create reader of servers from a file with a list of servers
+func NewFileReaderFromFile(filename string) (*FileReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	f := NewFileReader(file)
+	f.filename = filename
+	f.file = file
+	return f, nil
+}
+
+// SetServerType Set type of server for lines that do not have one
+func (f *FileReader) SetServerType(serverType enrichers.ServerType) {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+	f.serverType = serverType
+}
+
+// Malformed Get the malformed lines skipped so far
+func (f *FileReader) Malformed() []*LineError {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+	return append([]*LineError{}, f.malformed...)
+}
+
+// ReadServer Read next server in the list
+func (f *FileReader) ReadServer() (genericenricher.Server, error) {
+	f.readLock.Lock()
+	defer f.readLock.Unlock()
+
+	if f.isClosed() {
+		return nil, io.EOF
+	}
+
+	for f.scanner.Scan() {
+		if f.isClosed() {
+			return nil, io.EOF
+		}
+		f.line++
+		text := strings.TrimSpace(f.scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		server, err := f.parseLine(text)
+		if err != nil && f.isClosed() {
+			// Probing the port was stopped
+			return nil, io.EOF
+		}
+		if err != nil {
+			lineErr := &LineError{Line: f.line, Text: text, Err: err}
+			f.stateLock.Lock()
+			f.malformed = append(f.malformed, lineErr)
+			f.stateLock.Unlock()
+			if f.Strict {
+				return nil, lineErr
+			}
+			continue
+		}
+		return server, nil
+	}
+
+	if f.isClosed() {
+		// Reading the file we opened failed as it was closed
+		return nil, io.EOF
+	}
+	if err := f.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// isClosed Check if Close was called
+func (f *FileReader) isClosed() bool {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+	return f.closed
+}
+
+// parseLine Get server from a line of the list
+func (f *FileReader) parseLine(text string) (genericenricher.Server, error) {
+	fields := strings.Fields(text)
+	if len(fields) > 2 {
+		return nil, errors.New("too many fields")
+	}
+
+	f.stateLock.Lock()
+	serverType := f.serverType
+	f.stateLock.Unlock()
+	tls := false
+	if len(fields) == 2 {
+		var err error
+		serverType, err = ParseServerType(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		tls = strings.EqualFold(fields[1], "https")
+	}
+
+	connectString := fields[0]
+	if strings.Contains(connectString, "://") {
+		// Connection string
+		u, err := url.Parse(connectString)
+		if err != nil || u.Hostname() == "" {
+			return nil, errors.New("invalid url")
+		}
+	} else {
+		// host:port
+		host, portString, err := net.SplitHostPort(connectString)
+		if err != nil {
+			return nil, errors.New("expected url or host:port")
+		}
+		port, err := strconv.Atoi(portString)
+		if err != nil || port < 1 || port > 65535 || host == "" {
+			return nil, errors.New("invalid host or port")
+		}
+		if serverType == enrichers.Unknown {
+			fingerprint, err := f.probe(host, port)
+			if err != nil {
+				return nil, fmt.Errorf("no server type, and could not probe port: %v", err)
+			}
+			if fingerprint.ServerType == enrichers.Unknown {
+				return nil, errors.New("no server type, and could not detect it")
+			}
+			serverType, tls = fingerprint.ServerType, fingerprint.TLS
+		}
+		connectString = target{host: host, port: port, serverType: serverType, tls: tls}.connectionString()
+	}
+
+	return getServer(connectString, serverType)
+}
+
+// probe Detect the type of server on a port, stopped by Close
+func (f *FileReader) probe(host string, port int) (*Fingerprint, error) {
+	f.stateLock.Lock()
+	if f.closed {
+		f.stateLock.Unlock()
+		return nil, errors.New("reader closed")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancelProbe = cancel
+	f.stateLock.Unlock()
+	defer func() {
+		f.stateLock.Lock()
+		f.cancelProbe = nil
+		f.stateLock.Unlock()
+		cancel()
+	}()
+
+	return fingerprintTarget(ctx, (&net.Dialer{}).DialContext, host, host, port, f.Timeout)
+}
+
+// Close stop reading servers
+func (f *FileReader) Close() error {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+	f.closed = true
+	if f.cancelProbe != nil {
+		f.cancelProbe()
+	}
+	if f.file != nil {
+		err := f.file.Close()
+		f.file = nil
+		return err
+	}
+	return nil
+}
+
+// Reset start reading from the start of the list again
+func (f *FileReader) Reset() error {
+	if f.filename == "" {
+		// Checked before waiting for a read, which could be blocked on os.Stdin
+		if _, ok := f.source.(io.Seeker); !ok {
+			return errors.New("cannot reset reader that is not an io.Seeker")
+		}
+	}
+
+	// Stop a read in progress
+	f.Close()
+	f.readLock.Lock()
+	defer f.readLock.Unlock()
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+	if f.filename != "" {
+		// Open the file again
+		file, err := os.Open(f.filename)
+		if err != nil {
+			return err
+		}
+		f.file = file
+		f.source = file
+	} else if _, err := f.source.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	f.scanner = bufio.NewScanner(f.source)
+	f.line = 0
+	f.closed = false
+	f.malformed = nil
+	return nil
+}
@@ -0,0 +1,154 @@
+package serverreaders
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+func TestFileReader(t *testing.T) {
+	f, err := NewFileReaderFromFile(filepath.Join("testdata", "targets.txt"))
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	expected := []struct {
+		connectString string
+		serverType    enrichers.ServerType
+	}{
+		{"http://10.0.0.1:9200", enrichers.ELK},
+		{"ftp://10.0.0.2:21", enrichers.FTP},
+		{"http://[2001:db8::1]:80", enrichers.HTTP},
+		{"http://db.internal:9200", enrichers.ELK},
+	}
+	for i := 0; i < 2; i++ {
+		for _, e := range expected {
+			server, err := f.ReadServer()
+			if err != nil {
+				t.Errorf(err.Error())
+				return
+			}
+			if server.GetConnectString() != e.connectString || server.Type() != e.serverType {
+				t.Errorf("Expected %s (%s), got %s (%s)", e.connectString, e.serverType, server.GetConnectString(), server.Type())
+			}
+		}
+
+		// Check EOFs
+		if _, err := f.ReadServer(); err != io.EOF {
+			t.Errorf("Should have been EOF")
+		}
+		if _, err := f.ReadServer(); err != io.EOF {
+			t.Errorf("Should have been EOF")
+		}
+
+		// Check malformed lines were reported
+		malformed := f.Malformed()
+		if len(malformed) != 2 || malformed[0].Line != 7 || malformed[1].Line != 8 {
+			t.Errorf("Did not report malformed lines: %v", malformed)
+		}
+
+		// Read again after reset
+		f.Close()
+		if _, err := f.ReadServer(); err != io.EOF {
+			t.Errorf("Should have been EOF after close")
+		}
+		if err := f.Reset(); err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+	}
+}
+
+func TestFileReaderStrict(t *testing.T) {
+	f := NewFileReader(strings.NewReader("127.0.0.1:1\n10.0.0.4:80 gopher\n10.0.0.2:80 http\n10.0.0.3:443 https\n"))
+	f.Strict = true
+
+	// No server type, and nothing listening to detect it
+	_, err := f.ReadServer()
+	if lineErr, ok := err.(*LineError); !ok || lineErr.Line != 1 {
+		t.Errorf("Expected error on line 1, got %v", err)
+	}
+	_, err = f.ReadServer()
+	if lineErr, ok := err.(*LineError); !ok || lineErr.Line != 2 {
+		t.Errorf("Expected error on line 2, got %v", err)
+	}
+
+	// Continues after the error
+	server, err := f.ReadServer()
+	if err != nil || server.GetConnectString() != "http://10.0.0.2:80" {
+		t.Errorf("Did not continue after malformed line")
+	}
+
+	// TLS
+	server, err = f.ReadServer()
+	if err != nil || server.GetConnectString() != "https://10.0.0.3:443" || server.Type() != enrichers.HTTP {
+		t.Errorf("Did not keep https, got %v", server)
+	}
+
+	// Default server type
+	f.SetServerType(enrichers.HTTP)
+	if err := f.Reset(); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if server, err := f.ReadServer(); err != nil || server.GetConnectString() != "http://127.0.0.1:1" {
+		t.Errorf("Did not use default server type")
+	}
+}
+
+func TestFileReaderDetectType(t *testing.T) {
+	elastic := httptest.NewServer(http.HandlerFunc(elasticHandler))
+	defer elastic.Close()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer web.Close()
+	ftpPort, closeFTP := greetingServer(t, []byte("220 (vsFTPd 3.0.3)\r\n"))
+	defer closeFTP()
+	silentPort, closeSilent := greetingServer(t, nil)
+	defer closeSilent()
+	closedPort, closeClosed := greetingServer(t, nil)
+	closeClosed()
+
+	// host:port lines without a type are probed to detect it
+	list := fmt.Sprintf("127.0.0.1:%d\n127.0.0.1:%d\n127.0.0.1:%d\n127.0.0.1:%d\n127.0.0.1:%d\n",
+		httptestPort(elastic), httptestPort(web), ftpPort, silentPort, closedPort)
+	f := NewFileReader(strings.NewReader(list))
+	f.Timeout = time.Second
+	expected := []struct {
+		connectString string
+		serverType    enrichers.ServerType
+	}{
+		{fmt.Sprintf("http://127.0.0.1:%d", httptestPort(elastic)), enrichers.ELK},
+		{fmt.Sprintf("http://127.0.0.1:%d", httptestPort(web)), enrichers.HTTP},
+		{fmt.Sprintf("ftp://127.0.0.1:%d", ftpPort), enrichers.FTP},
+	}
+	for _, e := range expected {
+		server, err := f.ReadServer()
+		if err != nil || server.GetConnectString() != e.connectString || server.Type() != e.serverType {
+			t.Errorf("Expected %s (%s), got %v %v", e.connectString, e.serverType, server, err)
+		}
+	}
+	if _, err := f.ReadServer(); err != io.EOF {
+		t.Errorf("Should have been EOF, got %v", err)
+	}
+
+	// Lines where the type could not be detected are malformed
+	malformed := f.Malformed()
+	if len(malformed) != 2 || malformed[0].Line != 4 || malformed[1].Line != 5 {
+		t.Errorf("Did not report lines without a type that could not be detected: %v", malformed)
+	}
+}
+
+func TestFileReaderResetNotSeekable(t *testing.T) {
+	f := NewFileReader(io.MultiReader(strings.NewReader("http://10.0.0.1\n")))
+	if err := f.Reset(); err == nil {
+		t.Errorf("Should not reset reader that can't seek")
+	}
+}
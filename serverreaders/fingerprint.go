@@ -0,0 +1,161 @@
+package serverreaders
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+const (
+	// fingerprintGreetingWait Time to wait for a server to send a greeting before probing it
+	fingerprintGreetingWait = time.Millisecond * 500
+	// fingerprintBannerSize Bytes of the banner to read
+	fingerprintBannerSize = 4 * 1024
+)
+
+// Fingerprint What a port scan found running on an open port
+type Fingerprint struct {
+	ServerType enrichers.ServerType // Unknown if the service was not recognized
+	TLS        bool
+	Banner     []byte // Greeting sent by the server, or the start of its response to a probe
+}
+
+// FingerprintedServer Server returned by a Scanner with Fingerprint set, with what was detected on its port
+type FingerprintedServer struct {
+	genericenricher.Server
+	Fingerprint *Fingerprint
+}
+
+// dialFunc Function to open connections, such as net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// FingerprintTarget Detect the type of server on a port, and if it uses TLS.
+// The server is given a chance to send a greeting (FTP, SSH, MySQL), then it is probed with HTTP, then HTTP over TLS.
+// Returns an error if we can't connect
+func FingerprintTarget(ctx context.Context, host string, port int, timeout time.Duration) (*Fingerprint, error) {
+	return fingerprintTarget(ctx, (&net.Dialer{}).DialContext, host, host, port, timeout)
+}
+
+// fingerprintTarget Fingerprint a port of the ip (or hostname) address, with host used as the HTTP Host and TLS server name
+func fingerprintTarget(ctx context.Context, dial dialFunc, address string, host string, port int, timeout time.Duration) (*Fingerprint, error) {
+	address = net.JoinHostPort(address, strconv.Itoa(port))
+	fingerprint := &Fingerprint{}
+
+	// Wait for a greeting
+	conn, err := dialWithTimeout(ctx, dial, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	wait := fingerprintGreetingWait
+	if timeout < wait {
+		wait = timeout
+	}
+	greeting := readGreeting(conn, wait)
+	if len(greeting) > 0 {
+		conn.Close()
+		fingerprint.Banner = greeting
+		fingerprint.ServerType = greetingServerType(greeting)
+		return fingerprint, nil
+	}
+
+	// Probe with HTTP
+	response := httpProbe(conn, host, timeout)
+	conn.Close()
+	if bytes.HasPrefix(response, []byte("HTTP/")) && !wantsTLS(response) {
+		fingerprint.Banner = response
+		fingerprint.ServerType = httpServerType(response)
+		return fingerprint, nil
+	}
+
+	// Probe with HTTP over TLS
+	conn, err = dialWithTimeout(ctx, dial, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: host})
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		// Not TLS, and we don't know what it is
+		fingerprint.Banner = response
+		return fingerprint, nil
+	}
+	fingerprint.TLS = true
+	response = httpProbe(tlsConn, host, timeout)
+	fingerprint.Banner = response
+	if bytes.HasPrefix(response, []byte("HTTP/")) {
+		fingerprint.ServerType = httpServerType(response)
+	}
+	return fingerprint, nil
+}
+
+// dialWithTimeout Connect to a tcp address
+func dialWithTimeout(ctx context.Context, dial dialFunc, address string, timeout time.Duration) (net.Conn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return dial(dialCtx, "tcp", address)
+}
+
+// readGreeting Read the first data the server sends within wait
+func readGreeting(conn net.Conn, wait time.Duration) []byte {
+	conn.SetReadDeadline(time.Now().Add(wait))
+	greeting := make([]byte, fingerprintBannerSize)
+	n, _ := conn.Read(greeting)
+	return greeting[:n]
+}
+
+// readBanner Read what the server sends until it closes the connection, up to fingerprintBannerSize bytes within wait
+func readBanner(conn net.Conn, wait time.Duration) []byte {
+	conn.SetReadDeadline(time.Now().Add(wait))
+	banner := make([]byte, fingerprintBannerSize)
+	n, _ := io.ReadFull(conn, banner)
+	return banner[:n]
+}
+
+// httpProbe Send a HTTP request and get the start of the response
+func httpProbe(conn net.Conn, host string, timeout time.Duration) []byte {
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte("GET / HTTP/1.0\r\nHost: " + host + "\r\nUser-Agent: serverpatdown\r\nConnection: close\r\n\r\n")); err != nil {
+		return nil
+	}
+	return readBanner(conn, timeout)
+}
+
+// wantsTLS Check if a HTTP response says the request should have used TLS, such as from nginx or Go
+func wantsTLS(response []byte) bool {
+	lower := bytes.ToLower(response)
+	return bytes.Contains(lower, []byte("sent to https port")) || bytes.Contains(lower, []byte("request to an https server"))
+}
+
+// greetingServerType Get the type of server from the greeting it sent
+func greetingServerType(greeting []byte) enrichers.ServerType {
+	switch {
+	case bytes.HasPrefix(greeting, []byte("SSH-")):
+		return enrichers.SSH
+	case bytes.HasPrefix(greeting, []byte("220")) && !bytes.Contains(bytes.ToUpper(greeting), []byte("SMTP")):
+		return enrichers.FTP
+	case len(greeting) > 5 && greeting[3] == 0 && greeting[4] == 10:
+		// MySQL handshake packet: 3 byte length, sequence 0, protocol version 10
+		return enrichers.SQL
+	default:
+		return enrichers.Unknown
+	}
+}
+
+// httpServerType Get the type of server from a HTTP response
+func httpServerType(response []byte) enrichers.ServerType {
+	lower := strings.ToLower(string(response))
+	if strings.Contains(lower, "you know, for search") || strings.Contains(lower, `"cluster_name"`) ||
+		strings.Contains(lower, "x-elastic-product: elasticsearch") {
+		return enrichers.ELK
+	}
+	return enrichers.HTTP
+}
@@ -0,0 +1,141 @@
+package serverreaders
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// greetingServer Listen on a local port, sending greeting to each connection (nothing if empty)
+func greetingServer(t *testing.T, greeting []byte) (port int, close func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if len(greeting) > 0 {
+				conn.Write(greeting)
+			}
+			go func() {
+				// Hold the connection open until the client is done
+				conn.Read(make([]byte, 1024))
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, func() { listener.Close() }
+}
+
+// httptestPort Get the port of a test server
+func httptestPort(ts *httptest.Server) int {
+	return ts.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func elasticHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, `{"name": "node-1", "cluster_name": "elasticsearch", "tagline": "You Know, for Search"}`)
+}
+
+func TestFingerprintTarget(t *testing.T) {
+	ftpPort, closeFTP := greetingServer(t, []byte("220 (vsFTPd 3.0.3)\r\n"))
+	defer closeFTP()
+	sshPort, closeSSH := greetingServer(t, []byte("SSH-2.0-OpenSSH_8.9\r\n"))
+	defer closeSSH()
+	mysqlPort, closeMySQL := greetingServer(t, []byte{0x4a, 0, 0, 0, 0x0a, '8', '.', '0', '.', '3', '2', 0})
+	defer closeMySQL()
+	silentPort, closeSilent := greetingServer(t, nil)
+	defer closeSilent()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello")
+	}))
+	defer web.Close()
+	elastic := httptest.NewServer(http.HandlerFunc(elasticHandler))
+	defer elastic.Close()
+	elasticTLS := httptest.NewTLSServer(http.HandlerFunc(elasticHandler))
+	defer elasticTLS.Close()
+
+	tests := []struct {
+		port       int
+		serverType enrichers.ServerType
+		tls        bool
+	}{
+		{ftpPort, enrichers.FTP, false},
+		{sshPort, enrichers.SSH, false},
+		{mysqlPort, enrichers.SQL, false},
+		{silentPort, enrichers.Unknown, false},
+		{httptestPort(web), enrichers.HTTP, false},
+		{httptestPort(elastic), enrichers.ELK, false},
+		{httptestPort(elasticTLS), enrichers.ELK, true},
+	}
+	for _, test := range tests {
+		fingerprint, err := FingerprintTarget(context.Background(), "127.0.0.1", test.port, time.Second)
+		if err != nil {
+			t.Errorf("%d: %v", test.port, err)
+			continue
+		}
+		if fingerprint.ServerType != test.serverType || fingerprint.TLS != test.tls {
+			t.Errorf("Expected %s (tls %v), got %s (tls %v) from `%s`", test.serverType, test.tls, fingerprint.ServerType, fingerprint.TLS, fingerprint.Banner)
+		}
+	}
+
+	// Closed port
+	closedPort, closeClosed := greetingServer(t, nil)
+	closeClosed()
+	if _, err := FingerprintTarget(context.Background(), "127.0.0.1", closedPort, time.Second); err == nil {
+		t.Errorf("Should fail to connect to closed port")
+	}
+}
+
+func TestScannerFingerprint(t *testing.T) {
+	ftpPort, closeFTP := greetingServer(t, []byte("220 FTP server ready\r\n"))
+	defer closeFTP()
+	elasticTLS := httptest.NewTLSServer(http.HandlerFunc(elasticHandler))
+	defer elasticTLS.Close()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello")
+	}))
+	defer web.Close()
+	closedPort, closeClosed := greetingServer(t, nil)
+	closeClosed()
+
+	s := NewScanner()
+	s.AddIPNet(net.IPNet{IP: net.IP{127, 0, 0, 1}, Mask: net.CIDRMask(32, 32)})
+	s.Ports = []int{ftpPort, httptestPort(elasticTLS), httptestPort(web), closedPort}
+	s.Fingerprint = true
+	s.Timeout = time.Second
+
+	expected := map[string]string{
+		fmt.Sprintf("ftp://127.0.0.1:%d", ftpPort):                    "FTP",
+		fmt.Sprintf("https://127.0.0.1:%d", httptestPort(elasticTLS)): "ELK",
+		fmt.Sprintf("http://127.0.0.1:%d", httptestPort(web)):         "HTTP",
+	}
+	count := 0
+	for {
+		server, err := s.ReadServer()
+		if err != nil {
+			break
+		}
+		count++
+		fingerprinted, ok := server.(*FingerprintedServer)
+		if !ok {
+			t.Errorf("Server should be a *FingerprintedServer")
+			continue
+		}
+		if expected[server.GetConnectString()] != server.Type().String() || fingerprinted.Fingerprint.ServerType != server.Type() {
+			t.Errorf("Unexpected server %s %s", server.GetConnectString(), server.Type())
+		}
+	}
+	if count != len(expected) {
+		t.Errorf("Expected %d servers, got %d", len(expected), count)
+	}
+}
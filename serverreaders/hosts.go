@@ -0,0 +1,176 @@
+package serverreaders
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vertoforce/genericenricher"
+)
+
+// hostnameRegex Valid hostname, without wildcards
+var hostnameRegex = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?(\.[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?)*$`)
+
+// Resolver Looks up the ips of hostnames, such as net.DefaultResolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// hostTarget Hostname and the ip to connect to
+type hostTarget struct {
+	name string
+	ip   net.IP
+}
+
+// ErrHostExcluded Hostname of a HostServer resolved to an excluded ip when connecting, so it was not connected to
+var ErrHostExcluded = errors.New("hostname resolves to an excluded ip")
+
+// HostServer Server of a hostname added with Scanner.AddHost.
+// The hostname is kept in the connection string so HTTP requests have the right Host, so the server resolves it again
+// with the system resolver when connecting.  That can give a different ip than Scanner.Resolver did when the exclusions
+// were checked and the port probed, such as with split-horizon or round-robin DNS, so Connect checks the ips the system
+// resolver gives against the exclusions again.  The ip could still change between that check and connecting
+type HostServer struct {
+	genericenricher.Server
+	Host       string
+	IP         net.IP // Ip Scanner.Resolver gave, which the port was probed on
+	exclusions []net.IPNet
+	resolver   Resolver // Resolver the server connects with, net.DefaultResolver
+}
+
+// Connect Connect to the server, unless the hostname now resolves to an excluded ip
+func (h *HostServer) Connect(ctx context.Context) error {
+	addrs, err := h.resolver.LookupIPAddr(ctx, h.Host)
+	if err != nil {
+		return err
+	}
+	excluded := ipSet(h.exclusions)
+	for _, addr := range addrs {
+		if excluded(addr.IP) {
+			return ErrHostExcluded
+		}
+	}
+	return h.Server.Connect(ctx)
+}
+
+// AddHost Add hostname to scan on each port.  The hostname is resolved when scanning starts, and kept in the
+// connection string of its servers so HTTP requests have the right Host, see HostServer.  An ip is added as a network instead
+func (s *Scanner) AddHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		n, _ := ParseIPNet(host)
+		s.AddIPNet(n)
+		return nil
+	}
+	labels := strings.Split(host, ".")
+	if _, err := strconv.Atoi(labels[len(labels)-1]); err == nil || !hostnameRegex.MatchString(host) {
+		// A top level domain can't be a number, so it is likely an invalid ip
+		return fmt.Errorf("invalid hostname `%s`", host)
+	}
+	s.Hosts = append(s.Hosts, host)
+	return nil
+}
+
+// LoadHostsFromFile Add hostnames to scan from a file with one hostname per line.
+// Blank lines and text after # are skipped.  Returns a *LineError for invalid lines
+func (s *Scanner) LoadHostsFromFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if err := s.AddHost(text); err != nil {
+			return &LineError{Line: line, Text: text, Err: err}
+		}
+	}
+	return scanner.Err()
+}
+
+// UnresolvedHosts Get the hostnames that could not be resolved, which are skipped
+func (s *Scanner) UnresolvedHosts() []string {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return append([]string{}, s.unresolved...)
+}
+
+// resolveHosts Resolve each hostname to the ip to connect to, preferring IPv4.
+// Hostnames resolving to an excluded ip are skipped, and with DedupeHosts so are
+// hostnames resolving to an ip in Nets or of a hostname before it
+func (s *Scanner) resolveHosts(ctx context.Context) []hostTarget {
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	exclusions := ipSet(s.Exclusions)
+	nets := ipSet(s.Nets)
+	seen := map[string]bool{}
+
+	unresolved := []string{}
+	hosts := []hostTarget{}
+	for _, name := range s.Hosts {
+		lookupCtx, cancel := context.WithTimeout(ctx, s.Timeout)
+		addrs, err := resolver.LookupIPAddr(lookupCtx, name)
+		cancel()
+		if err != nil || len(addrs) == 0 {
+			unresolved = append(unresolved, name)
+			continue
+		}
+
+		target := hostTarget{name: name}
+		excluded, duplicate := false, false
+		for _, addr := range addrs {
+			if exclusions(addr.IP) {
+				excluded = true
+			}
+			if s.DedupeHosts && (seen[addr.IP.String()] || nets(addr.IP)) {
+				duplicate = true
+			}
+			if target.ip == nil || (target.ip.To4() == nil && addr.IP.To4() != nil) {
+				target.ip = addr.IP
+			}
+		}
+		for _, addr := range addrs {
+			seen[addr.IP.String()] = true
+		}
+		if excluded || duplicate {
+			continue
+		}
+		hosts = append(hosts, target)
+	}
+
+	s.stateLock.Lock()
+	s.unresolved = unresolved
+	s.stateLock.Unlock()
+	return hosts
+}
+
+// ipSet Get function to check if an ip is in any of the networks
+func ipSet(nets []net.IPNet) func(net.IP) bool {
+	return func(ip net.IP) bool {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+}
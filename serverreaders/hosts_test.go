@@ -0,0 +1,170 @@
+package serverreaders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// fakeResolver Resolver with fixed ips for each hostname
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs := []net.IPAddr{}
+	for _, ip := range r[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func TestScannerHosts(t *testing.T) {
+	resolver := fakeResolver{
+		"app.test":     {"10.0.0.5"},
+		"www.app.test": {"10.0.0.5"},
+		"other.test":   {"2001:db8::1", "10.0.0.6"},
+		"blocked.test": {"10.9.9.9"},
+	}
+	newScanner := func() *Scanner {
+		s := NewScanner()
+		s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 5}, Mask: net.CIDRMask(32, 32)})
+		if err := s.LoadHostsFromFile(filepath.Join("testdata", "hosts.txt")); err != nil {
+			t.Fatal(err)
+		}
+		s.AddExclusion(net.IPNet{IP: net.IP{10, 9, 0, 0}, Mask: net.CIDRMask(16, 32)})
+		s.AddPort(80)
+		s.SetServerType(enrichers.HTTP)
+		s.Resolver = resolver
+		return s
+	}
+	readAll := func(s *Scanner) string {
+		servers := []string{}
+		for {
+			server, err := s.ReadServer()
+			if err != nil {
+				break
+			}
+			servers = append(servers, server.GetConnectString())
+		}
+		return strings.Join(servers, ",")
+	}
+
+	s := newScanner()
+	if plan, _ := s.Plan(); plan.Hosts != 5 || plan.Targets.Int64() != 6 {
+		t.Errorf("Expected 5 hosts and 6 targets, got %d and %s", plan.Hosts, plan.Targets)
+	}
+	expected := "http://10.0.0.5:80,http://app.test:80,http://www.app.test:80,http://other.test:80"
+	if got := readAll(s); got != expected {
+		t.Errorf("Got %s", got)
+	}
+	if fmt.Sprint(s.UnresolvedHosts()) != "[missing.test]" {
+		t.Errorf("Got unresolved hosts %v", s.UnresolvedHosts())
+	}
+
+	s = newScanner()
+	s.DedupeHosts = true
+	expected = "http://10.0.0.5:80,http://other.test:80"
+	if got := readAll(s); got != expected {
+		t.Errorf("Got %s with DedupeHosts", got)
+	}
+
+	// Invalid hosts
+	s = NewScanner()
+	for _, host := range []string{"*.example.com", "example.com:80", "", "10.0.0.300", "bad host"} {
+		if err := s.AddHost(host); err == nil {
+			t.Errorf("`%s` should be invalid", host)
+		}
+	}
+	if err := s.AddHost("10.0.0.1"); err != nil || len(s.Nets) != 1 || len(s.Hosts) != 0 {
+		t.Errorf("IP should be added as a network")
+	}
+
+	s, err := NewScannerFromSpec("app.test,10.0.0.0/30:80")
+	if err != nil || fmt.Sprint(s.Hosts) != "[app.test]" || len(s.Nets) != 1 {
+		t.Errorf("Did not parse hostname in spec: %v", err)
+	}
+}
+
+func TestScannerHostHeader(t *testing.T) {
+	lock := sync.Mutex{}
+	hosts := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hosts = append(hosts, r.Host)
+		lock.Unlock()
+		fmt.Fprintln(w, "Hello")
+	}))
+	defer ts.Close()
+	port := ts.Listener.Addr().(*net.TCPAddr).Port
+
+	s := NewScanner()
+	s.AddHost("vhost.test")
+	s.AddPort(port)
+	s.Resolver = fakeResolver{"vhost.test": {"127.0.0.1"}}
+	s.Fingerprint = true
+	s.Timeout = time.Second
+
+	server, err := s.ReadServer()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if server.GetConnectString() != fmt.Sprintf("http://vhost.test:%d", port) {
+		t.Errorf("Hostname not in connection string: %s", server.GetConnectString())
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(hosts) == 0 || hosts[0] != "vhost.test" {
+		t.Errorf("Probe did not send the hostname, got %v", hosts)
+	}
+}
+
+func TestHostServerExclusions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello")
+	}))
+	defer ts.Close()
+	port := ts.Listener.Addr().(*net.TCPAddr).Port
+
+	for _, test := range []struct {
+		connectIP string // Ip the hostname resolves to when connecting
+		err       error
+	}{
+		{"127.0.0.1", nil},
+		{"10.9.9.9", ErrHostExcluded},
+	} {
+		s := NewScanner()
+		s.AddHost("localhost")
+		s.AddPort(port)
+		s.AddExclusion(net.IPNet{IP: net.IP{10, 9, 0, 0}, Mask: net.CIDRMask(16, 32)})
+		s.SetServerType(enrichers.HTTP)
+		s.Resolver = fakeResolver{"localhost": {"127.0.0.1"}}
+		s.connectResolver = fakeResolver{"localhost": {test.connectIP}}
+
+		server, err := s.ReadServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hostServer, ok := server.(*HostServer)
+		if !ok || hostServer.Host != "localhost" || !hostServer.IP.Equal(net.IP{127, 0, 0, 1}) {
+			t.Errorf("Expected HostServer of localhost, got %#v", server)
+			continue
+		}
+		if err := server.Connect(context.Background()); err != test.err {
+			t.Errorf("Resolving to %s when connecting: expected %v, got %v", test.connectIP, test.err, err)
+		}
+		server.Close()
+	}
+}
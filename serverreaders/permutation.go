@@ -0,0 +1,63 @@
+package serverreaders
+
+import "math/bits"
+
+const permutationRounds = 4
+
+// permutation Pseudo-random ordering of the numbers 0 to n-1, computed one number at a time without storing them.
+// This is a Feistel network over the smallest even number of bits that fits n, skipping numbers
+// of n or more by applying it again (cycle walking)
+type permutation struct {
+	n        uint64
+	halfBits uint
+	halfMask uint64
+	keys     [permutationRounds]uint64
+}
+
+// newPermutation Create permutation of 0 to n-1, the same seed always gives the same order
+func newPermutation(n uint64, seed int64) *permutation {
+	size := uint(2)
+	if n > 1 {
+		size = uint(bits.Len64(n - 1))
+		if size%2 == 1 {
+			size++
+		}
+		if size < 2 {
+			size = 2
+		}
+	}
+	p := &permutation{n: n, halfBits: size / 2, halfMask: (uint64(1) << (size / 2)) - 1}
+	state := uint64(seed)
+	for i := range p.keys {
+		state = splitMix64(state)
+		p.keys[i] = state
+	}
+	return p
+}
+
+// at Get the number at position i, for i less than n
+func (p *permutation) at(i uint64) uint64 {
+	for {
+		i = p.encrypt(i)
+		if i < p.n {
+			return i
+		}
+	}
+}
+
+// encrypt Apply the Feistel network once
+func (p *permutation) encrypt(i uint64) uint64 {
+	left, right := i>>p.halfBits, i&p.halfMask
+	for _, key := range p.keys {
+		left, right = right, left^(splitMix64(right^key)&p.halfMask)
+	}
+	return left<<p.halfBits | right
+}
+
+// splitMix64 Mix the bits of x
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,141 @@
+package serverreaders
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+func TestPermutation(t *testing.T) {
+	for _, n := range []uint64{0, 1, 2, 3, 7, 100, 1000, 4096} {
+		p := newPermutation(n, 42)
+		seen := make([]bool, n)
+		inOrder := true
+		for i := uint64(0); i < n; i++ {
+			v := p.at(i)
+			if v >= n || seen[v] {
+				t.Errorf("n=%d: %d is out of range or repeated", n, v)
+				break
+			}
+			seen[v] = true
+			if v != i {
+				inOrder = false
+			}
+		}
+		if n >= 100 && inOrder {
+			t.Errorf("n=%d: Not shuffled", n)
+		}
+	}
+
+	// Same seed gives the same order
+	a, b, c := newPermutation(1000, 1), newPermutation(1000, 1), newPermutation(1000, 2)
+	same := true
+	for i := uint64(0); i < 1000; i++ {
+		if a.at(i) != b.at(i) {
+			t.Errorf("Same seed gave a different order")
+			break
+		}
+		if a.at(i) != c.at(i) {
+			same = false
+		}
+	}
+	if same {
+		t.Errorf("Different seeds gave the same order")
+	}
+
+	// Large ranges are fine
+	p := newPermutation(^uint64(0), 7)
+	if v := p.at(123456789); v == ^uint64(0) {
+		t.Errorf("Out of range")
+	}
+}
+
+func TestScannerRandomize(t *testing.T) {
+	newScanner := func() *Scanner {
+		s := NewScanner()
+		s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(28, 32)})
+		s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 1, 0}, Mask: net.CIDRMask(28, 32)})
+		s.AddPort(80)
+		s.AddPort(443)
+		s.SetServerType(enrichers.HTTP)
+		s.Randomize = true
+		return s
+	}
+	readAll := func(s *Scanner, limit int) []string {
+		servers := []string{}
+		for len(servers) < limit {
+			server, err := s.ReadServer()
+			if err != nil {
+				break
+			}
+			servers = append(servers, server.GetConnectString())
+		}
+		return servers
+	}
+
+	// Every pair is read once, and not in order
+	s := newScanner()
+	all := readAll(s, 1000)
+	seen := map[string]bool{}
+	for _, server := range all {
+		seen[server] = true
+	}
+	if len(all) != 64 || len(seen) != 64 {
+		t.Errorf("Expected 64 different servers, got %d (%d different)", len(all), len(seen))
+		return
+	}
+	if s.Seed == 0 {
+		t.Errorf("Seed was not picked")
+	}
+	sequential := 0
+	for i := 1; i < len(all); i++ {
+		if all[i-1][:len("http://10.0.0.")] == all[i][:len("http://10.0.0.")] {
+			sequential++
+		}
+	}
+	if sequential > 48 {
+		t.Errorf("Order is not random enough: %v", all)
+	}
+
+	// Resume continues in the same order
+	s = newScanner()
+	first := readAll(s, 20)
+	state, err := s.State()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	resumed := newScanner()
+	if err := resumed.SetState(state); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	rest := readAll(resumed, 1000)
+	again := newScanner()
+	again.Seed = s.Seed
+	expected := readAll(again, 1000)
+	got := append(first, rest...)
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d servers after resuming, got %d", len(expected), len(got))
+		return
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("Resumed scan is in a different order")
+			break
+		}
+	}
+
+	// GetIPs is random too
+	s = newScanner()
+	s.Seed = 5
+	ips := []net.IP{}
+	for ip := range s.GetIPs(context.Background()) {
+		ips = append(ips, ip)
+	}
+	if len(ips) != 32 || (ips[0][3] == 0 && ips[1][3] == 1 && ips[2][3] == 2) {
+		t.Errorf("Did not get random ips: %v", ips)
+	}
+}
@@ -0,0 +1,78 @@
+package serverreaders
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+)
+
+// ScanPlan What a Scanner will scan, see Scanner.Plan
+type ScanPlan struct {
+	IPs      *big.Int // Number of ips in all networks, without excluded ips
+	Hosts    int      // Number of hostnames, before they are resolved
+	Excluded *big.Int // Number of ips in the networks that are excluded
+	Ports    int
+	Targets  *big.Int // Number of ip/port pairs
+	Warnings []string // Problems that don't stop the scan, such as very large networks
+}
+
+// TooManyTargetsError Scanner has more ip/port pairs than it can scan
+type TooManyTargetsError struct {
+	Targets *big.Int
+	Max     uint64
+}
+
+func (e *TooManyTargetsError) Error() string {
+	return fmt.Sprintf("scan has %s targets, more than the maximum of %d", e.Targets.String(), e.Max)
+}
+
+// TargetCount Get the number of ip/port pairs the scanner has, which can be more than fits in a uint64 for IPv6 networks
+func (s *Scanner) TargetCount() *big.Int {
+	return s.plan().Targets
+}
+
+// Plan Get what the scanner will scan before starting.
+// Returns a *TooManyTargetsError with the plan if there are more ip/port pairs than MaxTargets or than can be scanned
+func (s *Scanner) Plan() (*ScanPlan, error) {
+	plan := s.plan()
+	if (len(s.Nets) == 0 && len(s.Hosts) == 0) || len(s.Ports) == 0 {
+		return plan, errors.New("scanner has no networks or no ports")
+	}
+
+	max := s.MaxTargets
+	if max == 0 || max > math.MaxUint64-1 {
+		// Leave room for the position after the last target
+		max = math.MaxUint64 - 1
+	}
+	if plan.Targets.Cmp(new(big.Int).SetUint64(max)) > 0 {
+		return plan, &TooManyTargetsError{Targets: plan.Targets, Max: max}
+	}
+	return plan, nil
+}
+
+// plan Count the targets of the scanner
+func (s *Scanner) plan() *ScanPlan {
+	plan := &ScanPlan{IPs: new(big.Int), Excluded: new(big.Int), Hosts: len(s.Hosts), Ports: len(s.Ports)}
+	for _, n := range s.Nets {
+		size := netRange(n).size()
+		plan.Excluded.Add(plan.Excluded, size)
+		if size.Cmp(new(big.Int).SetUint64(largeNetSize)) > 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("network %s has %s ips", netString(n), size.String()))
+		}
+	}
+	for _, r := range s.ranges() {
+		plan.IPs.Add(plan.IPs, r.size())
+	}
+	plan.Excluded.Sub(plan.Excluded, plan.IPs)
+	plan.Targets = new(big.Int).Add(plan.IPs, big.NewInt(int64(len(s.Hosts))))
+	plan.Targets.Mul(plan.Targets, big.NewInt(int64(len(s.Ports))))
+	return plan
+}
+
+// netString Get CIDR notation of a network
+func netString(n net.IPNet) string {
+	ones, _ := n.Mask.Size()
+	return fmt.Sprintf("%s/%d", n.IP.Mask(n.Mask).String(), ones)
+}
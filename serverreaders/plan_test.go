@@ -0,0 +1,87 @@
+package serverreaders
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+func TestPlan(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("10.0.0.0/16")
+	_, v6, _ := net.ParseCIDR("2001:db8::/64")
+	_, bigV4, _ := net.ParseCIDR("10.0.0.0/7")
+
+	tests := []struct {
+		nets     []net.IPNet
+		ports    []int
+		max      uint64
+		targets  string
+		warnings int
+		fails    bool
+	}{
+		{[]net.IPNet{*v4}, []int{80, 443}, DefaultMaxTargets, "131072", 0, false},
+		{[]net.IPNet{*v4}, []int{80, 443}, 1000, "131072", 0, true},
+		{[]net.IPNet{*bigV4}, []int{80}, DefaultMaxTargets, "33554432", 1, false},
+		{[]net.IPNet{*v6}, []int{80}, 0, "18446744073709551616", 1, true},
+		{[]net.IPNet{*v4, *v6}, []int{80}, DefaultMaxTargets, "18446744073709617152", 1, true},
+		{[]net.IPNet{*v4}, nil, DefaultMaxTargets, "0", 0, true},
+	}
+	for i, test := range tests {
+		s := NewScanner()
+		s.Nets = test.nets
+		s.Ports = test.ports
+		s.MaxTargets = test.max
+
+		plan, err := s.Plan()
+		if (err != nil) != test.fails {
+			t.Errorf("%d: Unexpected error %v", i, err)
+		}
+		if plan.Targets.String() != test.targets || s.TargetCount().String() != test.targets {
+			t.Errorf("%d: Expected %s targets, got %s", i, test.targets, plan.Targets.String())
+		}
+		if len(plan.Warnings) != test.warnings {
+			t.Errorf("%d: Got warnings %v", i, plan.Warnings)
+		}
+
+		// Scanner should refuse to start
+		if test.fails {
+			if _, err := s.ReadServer(); err == nil {
+				t.Errorf("%d: Scanner should refuse to scan", i)
+			}
+		}
+	}
+}
+
+func TestScannerIPv6(t *testing.T) {
+	_, v6, _ := net.ParseCIDR("2001:db8::/126")
+	s := NewScanner()
+	s.AddIPNet(*v6)
+	s.AddPort(9200)
+	s.SetServerType(enrichers.ELK)
+
+	expected := []string{"http://[2001:db8::]:9200", "http://[2001:db8::1]:9200", "http://[2001:db8::2]:9200", "http://[2001:db8::3]:9200"}
+	for i := 0; i < len(expected); i++ {
+		server, err := s.ReadServer()
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		if server.GetConnectString() != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], server.GetConnectString())
+		}
+	}
+
+	// Check ports on IPv6 addresses
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 not available")
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	if !s.portOpen(context.Background(), net.ParseIP("::1"), port, nil, nil) {
+		t.Errorf("Port " + strconv.Itoa(port) + " should be open")
+	}
+}
@@ -0,0 +1,160 @@
+package serverreaders
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServicePorts Default ports of services, used in port specs such as "http,elasticsearch"
+var ServicePorts = map[string][]int{
+	"ftp":           {21},
+	"ssh":           {22},
+	"http":          {80},
+	"https":         {443},
+	"http-alt":      {8080},
+	"mysql":         {3306},
+	"mssql":         {1433},
+	"oracle":        {1521},
+	"postgres":      {5432},
+	"postgresql":    {5432},
+	"redis":         {6379},
+	"mongodb":       {27017},
+	"cassandra":     {9042},
+	"couchdb":       {5984},
+	"elasticsearch": {9200, 9300},
+	"elastic":       {9200, 9300},
+	"kibana":        {5601},
+	"solr":          {8983},
+	"meilisearch":   {7700},
+}
+
+// PortGroups Named groups of ports, used in port specs such as "web,databases"
+var PortGroups = map[string][]int{
+	"web":       {80, 443, 3000, 5000, 8000, 8008, 8080, 8081, 8443, 8888},
+	"databases": {1433, 1521, 3306, 5432, 5984, 6379, 9042, 27017},
+	"search":    {5601, 7700, 8983, 9200, 9300},
+}
+
+// ParsePorts Parse a nmap style list of ports, such as "80,443,8000-8100,http,web".
+// Each item is a port, a range of ports, a service in ServicePorts, or a group in PortGroups.
+// Ports are returned in order without duplicates
+func ParsePorts(spec string) ([]int, error) {
+	ports := []int{}
+	seen := map[int]bool{}
+	add := func(port int) {
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+
+		// Service or group
+		if servicePorts, ok := ServicePorts[item]; ok {
+			for _, port := range servicePorts {
+				add(port)
+			}
+			continue
+		}
+		if groupPorts, ok := PortGroups[item]; ok {
+			for _, port := range groupPorts {
+				add(port)
+			}
+			continue
+		}
+
+		// Port or range
+		first, last := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			first, last = item[:i], item[i+1:]
+		}
+		start, err := parsePort(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port `%s`", item)
+		}
+		end, err := parsePort(last)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid port range `%s`", item)
+		}
+		for port := start; port <= end; port++ {
+			add(port)
+		}
+	}
+
+	if len(ports) == 0 {
+		return nil, errors.New("no ports")
+	}
+	return ports, nil
+}
+
+// parsePort Parse a single port number
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, errors.New("invalid port")
+	}
+	return port, nil
+}
+
+// AddPorts Add ports to scan from a port spec, see ParsePorts
+func (s *Scanner) AddPorts(spec string) error {
+	ports, err := ParsePorts(spec)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		s.AddPort(port)
+	}
+	return nil
+}
+
+// NewScannerFromSpec Create scanner from a target spec of networks and ports, such as "10.0.0.0/16:9200,9300".
+// Networks are separated by commas and can be single ips or hostnames.  IPv6 networks are in brackets, such as "[2001:db8::/64]:http".
+// See ParsePorts for the port syntax
+func NewScannerFromSpec(spec string) (*Scanner, error) {
+	spec = strings.TrimSpace(spec)
+
+	// Split networks and ports at the last colon not in brackets
+	split := -1
+	depth := 0
+	for i, c := range spec {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				split = i
+			}
+		}
+	}
+	if split < 0 {
+		return nil, fmt.Errorf("target spec `%s` has no ports, expected networks:ports", spec)
+	}
+
+	s := NewScanner()
+	for _, network := range strings.Split(spec[:split], ",") {
+		network = strings.TrimSpace(network)
+		if strings.HasPrefix(network, "[") && strings.HasSuffix(network, "]") {
+			network = network[1 : len(network)-1]
+		}
+		n, err := ParseIPNet(network)
+		if err == nil {
+			s.AddIPNet(n)
+		} else if s.AddHost(network) != nil {
+			return nil, fmt.Errorf("invalid network `%s` in target spec: %v", network, err)
+		}
+	}
+	if err := s.AddPorts(spec[split+1:]); err != nil {
+		return nil, fmt.Errorf("invalid ports in target spec: %v", err)
+	}
+	return s, nil
+}
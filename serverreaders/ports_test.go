@@ -0,0 +1,78 @@
+package serverreaders
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{"80", "[80]"},
+		{"80, 443,8000-8003", "[80 443 8000 8001 8002 8003]"},
+		{"http,Elasticsearch,80", "[80 9200 9300]"},
+		{"search", "[5601 7700 8983 9200 9300]"},
+		{"22-22,ssh", "[22]"},
+		{"0", ""},
+		{"65536", ""},
+		{"100-90", ""},
+		{"80-", ""},
+		{"gopher", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		ports, err := ParsePorts(test.spec)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("`%s` should fail", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("`%s`: %v", test.spec, err)
+			continue
+		}
+		if fmt.Sprint(ports) != test.expected {
+			t.Errorf("`%s`: expected %s, got %v", test.spec, test.expected, ports)
+		}
+	}
+}
+
+func TestNewScannerFromSpec(t *testing.T) {
+	tests := []struct {
+		spec  string
+		nets  string
+		ports string
+	}{
+		{"10.0.0.0/16:9200,9300", "[10.0.0.0/16]", "[9200 9300]"},
+		{"10.0.0.0/24,192.168.1.5:http", "[10.0.0.0/24 192.168.1.5/32]", "[80]"},
+		{"[2001:db8::/64]:web", "[2001:db8::/64]", "[80 443 3000 5000 8000 8008 8080 8081 8443 8888]"},
+		{"[2001:db8::1],10.0.0.1:21-22", "[2001:db8::1/128 10.0.0.1/32]", "[21 22]"},
+		{"10.0.0.0/16", "", ""},
+		{"10.0.0.0/33:80", "", ""},
+		{"10.0.0.0/16:", "", ""},
+		{"2001:db8::/64:80", "[2001:db8::/64]", "[80]"},
+	}
+	for _, test := range tests {
+		s, err := NewScannerFromSpec(test.spec)
+		if test.nets == "" {
+			if err == nil {
+				t.Errorf("`%s` should fail", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("`%s`: %v", test.spec, err)
+			continue
+		}
+		nets := []string{}
+		for _, n := range s.Nets {
+			nets = append(nets, n.String())
+		}
+		if fmt.Sprint(nets) != test.nets || fmt.Sprint(s.Ports) != test.ports {
+			t.Errorf("`%s`: got %v %v", test.spec, nets, s.Ports)
+		}
+	}
+}
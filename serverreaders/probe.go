@@ -0,0 +1,213 @@
+package serverreaders
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// probeRetries Times to retry a port when we have too many open files
+	probeRetries = 3
+)
+
+// probeResult ip/port pair and if its port is open
+type probeResult struct {
+	index       uint64 // Index of the pair, see ipWithPortAt
+	ip          net.IP
+	host        string // Hostname the ip is for, if from Hosts
+	port        int
+	open        bool
+	fingerprint *Fingerprint // Set if Fingerprint is set and the port is open
+}
+
+// probeFrom Get each ip/port pair of the networks then the hosts, starting at the index start, skipping the indexes in skip.
+// If CheckPortOpen or Fingerprint is set, the ports are checked with ProbeWorkers at once, and results are sent as they finish.
+// Also returns the number of ip/port pairs
+func (s *Scanner) probeFrom(ctx context.Context, start uint64, skip map[uint64]bool, hosts []hostTarget) (chan probeResult, uint64) {
+	skip = copyIndexes(skip)
+	ranges := s.ranges()
+	ports := append([]int{}, s.Ports...)
+	netCount := targetCount(ranges, len(ports))
+	count := netCount + uint64(len(hosts))*uint64(len(ports))
+	if count < netCount {
+		count = math.MaxUint64
+	}
+	order := s.order(count)
+
+	// Get ip/port pairs
+	pairs := make(chan probeResult)
+	go func() {
+		defer close(pairs)
+		for i := start; i < count; i++ {
+			if skip[i] {
+				continue
+			}
+			result := probeResult{index: i, open: true}
+			if j := order(i); j < netCount {
+				pair := ipWithPortAt(ranges, ports, j)
+				result.ip, result.port = pair.IP, pair.Port
+			} else {
+				j -= netCount
+				host := hosts[j/uint64(len(ports))]
+				result.ip, result.host, result.port = host.ip, host.name, ports[j%uint64(len(ports))]
+			}
+			select {
+			case pairs <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if !s.CheckPortOpen && !s.Fingerprint {
+		return pairs, count
+	}
+
+	// Check ports
+	results := make(chan probeResult)
+	workers := s.ProbeWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var inFlight chan struct{}
+	if s.MaxInFlight > 0 {
+		inFlight = make(chan struct{}, s.MaxInFlight)
+	}
+	limiter, stopLimiter := newRateLimiter(s.ProbeRate)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range pairs {
+				result.open = s.portOpen(ctx, result.ip, result.port, limiter, inFlight)
+				if result.open && s.Fingerprint {
+					result.fingerprint = s.fingerprint(ctx, result.ip, result.host, result.port, limiter, inFlight)
+				}
+				if ctx.Err() != nil {
+					// Check could have been cut short
+					return
+				}
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		stopLimiter()
+		close(results)
+	}()
+
+	return results, count
+}
+
+// portOpen Check if the port is open, waiting for the rate limiter and a free in flight slot (both can be nil).
+// Retries a few times if we have too many open files
+func (s *Scanner) portOpen(ctx context.Context, ip net.IP, port int, limiter <-chan time.Time, inFlight chan struct{}) bool {
+	address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+
+	for attempt := 0; ; attempt++ {
+		if !acquire(ctx, limiter, inFlight) {
+			return false
+		}
+		conn, err := dialWithTimeout(ctx, s.dialer(), address, s.Timeout)
+		if err == nil {
+			conn.Close()
+		}
+		release(inFlight)
+
+		if err == nil {
+			return true
+		}
+		if !strings.Contains(err.Error(), "too many open files") || attempt == probeRetries {
+			return false
+		}
+
+		// Wait for other sockets to close
+		select {
+		case <-time.After(time.Duration(attempt+1) * 100 * time.Millisecond):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// fingerprint Detect what is running on an open port, see FingerprintTarget.  Returns nil if we could not connect
+func (s *Scanner) fingerprint(ctx context.Context, ip net.IP, host string, port int, limiter <-chan time.Time, inFlight chan struct{}) *Fingerprint {
+	if !acquire(ctx, limiter, inFlight) {
+		return nil
+	}
+	defer release(inFlight)
+	if host == "" {
+		host = ip.String()
+	}
+	fingerprint, err := fingerprintTarget(ctx, s.dialer(), ip.String(), host, port, s.Timeout)
+	if err != nil {
+		return nil
+	}
+	return fingerprint
+}
+
+// dialer Get function to open connections
+func (s *Scanner) dialer() dialFunc {
+	if s.dial == nil {
+		return (&net.Dialer{}).DialContext
+	}
+	return s.dial
+}
+
+// acquire Wait for the rate limiter and a free in flight slot (both can be nil), returns false if the context is canceled first
+func acquire(ctx context.Context, limiter <-chan time.Time, inFlight chan struct{}) bool {
+	if limiter != nil {
+		select {
+		case <-limiter:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if inFlight != nil {
+		select {
+		case inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// release Free an in flight slot from acquire
+func release(inFlight chan struct{}) {
+	if inFlight != nil {
+		<-inFlight
+	}
+}
+
+// newRateLimiter Get channel that allows rate events per second, nil if rate is 0.  Call stop when done
+func newRateLimiter(rate float64) (limiter <-chan time.Time, stop func()) {
+	if rate <= 0 {
+		return nil, func() {}
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+// copyIndexes Copy a set of indexes
+func copyIndexes(indexes map[uint64]bool) map[uint64]bool {
+	c := make(map[uint64]bool, len(indexes))
+	for index := range indexes {
+		c[index] = true
+	}
+	return c
+}
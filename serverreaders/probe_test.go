@@ -0,0 +1,209 @@
+package serverreaders
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// fakeDialer Dialer that takes delay to connect, tracking the most connections at once
+type fakeDialer struct {
+	delay       time.Duration
+	failures    int // Fail with too many open files this many times
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+	dials       int
+}
+
+func (d *fakeDialer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	d.lock.Lock()
+	d.dials++
+	if d.failures > 0 {
+		d.failures--
+		d.lock.Unlock()
+		return nil, errors.New("dial tcp " + address + ": socket: too many open files")
+	}
+	d.inFlight++
+	if d.inFlight > d.maxInFlight {
+		d.maxInFlight = d.inFlight
+	}
+	d.lock.Unlock()
+
+	time.Sleep(d.delay + time.Duration(rand.Int63n(int64(time.Millisecond))))
+
+	d.lock.Lock()
+	d.inFlight--
+	d.lock.Unlock()
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+// readPorts Read all servers and get their ports
+func readPorts(t *testing.T, s *Scanner, limit int) []int {
+	ports := []int{}
+	for len(ports) < limit {
+		server, err := s.ReadServer()
+		if err != nil {
+			break
+		}
+		ports = append(ports, int(server.GetPort()))
+	}
+	return ports
+}
+
+func TestProbeListeners(t *testing.T) {
+	// Open some ports, and find some closed ones
+	open := map[int]bool{}
+	s := NewScanner()
+	s.AddIPNet(net.IPNet{IP: net.IP{127, 0, 0, 1}, Mask: net.CIDRMask(32, 32)})
+	for i := 0; i < 10; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		if i%2 == 0 {
+			defer listener.Close()
+			open[port] = true
+		} else {
+			listener.Close()
+		}
+		s.AddPort(port)
+	}
+	s.SetServerType(enrichers.HTTP)
+	s.CheckPortOpen = true
+	s.ProbeWorkers = 4
+	s.Timeout = time.Second
+
+	ports := readPorts(t, s, 100)
+	if len(ports) != len(open) {
+		t.Errorf("Expected %d open ports, got %v", len(open), ports)
+	}
+	for _, port := range ports {
+		if !open[port] {
+			t.Errorf("Port %d is not open", port)
+		}
+	}
+
+	// Rate limit
+	s.Reset()
+	s.ProbeRate = 20
+	start := time.Now()
+	readPorts(t, s, 100)
+	if time.Since(start) < time.Millisecond*400 {
+		t.Errorf("Probed 10 ports in %s, faster than 20 per second", time.Since(start))
+	}
+}
+
+func TestProbeInFlight(t *testing.T) {
+	newScanner := func(dialer *fakeDialer) *Scanner {
+		s := NewScanner()
+		s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(27, 32)})
+		s.AddPort(80)
+		s.SetServerType(enrichers.HTTP)
+		s.CheckPortOpen = true
+		s.ProbeWorkers = 16
+		s.dial = dialer.dial
+		return s
+	}
+
+	dialer := &fakeDialer{delay: time.Millisecond * 10}
+	if ports := readPorts(t, newScanner(dialer), 100); len(ports) != 32 {
+		t.Errorf("Expected 32 servers, got %d", len(ports))
+	}
+	if dialer.maxInFlight < 4 {
+		t.Errorf("Ports were not probed concurrently")
+	}
+
+	dialer = &fakeDialer{delay: time.Millisecond * 10}
+	s := newScanner(dialer)
+	s.MaxInFlight = 3
+	if ports := readPorts(t, s, 100); len(ports) != 32 {
+		t.Errorf("Expected 32 servers, got %d", len(ports))
+	}
+	if dialer.maxInFlight > 3 {
+		t.Errorf("Had %d sockets open at once, more than 3", dialer.maxInFlight)
+	}
+}
+
+func TestProbeTooManyOpenFiles(t *testing.T) {
+	s := NewScanner()
+	s.Timeout = time.Second
+
+	// Retries until it works
+	dialer := &fakeDialer{failures: 2}
+	s.dial = dialer.dial
+	if !s.portOpen(context.Background(), net.IP{10, 0, 0, 1}, 80, nil, nil) {
+		t.Errorf("Port should be open after retrying")
+	}
+
+	// Gives up eventually
+	dialer = &fakeDialer{failures: 100}
+	s.dial = dialer.dial
+	if s.portOpen(context.Background(), net.IP{10, 0, 0, 1}, 80, nil, nil) {
+		t.Errorf("Port should not be open")
+	}
+	if dialer.dials != probeRetries+1 {
+		t.Errorf("Expected %d attempts, got %d", probeRetries+1, dialer.dials)
+	}
+}
+
+func TestProbeState(t *testing.T) {
+	newScanner := func() *Scanner {
+		s := NewScanner()
+		s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(26, 32)})
+		s.AddPort(80)
+		s.SetServerType(enrichers.HTTP)
+		s.CheckPortOpen = true
+		s.ProbeWorkers = 8
+		s.dial = (&fakeDialer{delay: time.Millisecond}).dial
+		return s
+	}
+
+	// Read some servers, which finish out of order
+	s := newScanner()
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		server, err := s.ReadServer()
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		seen[server.GetIP().String()] = true
+	}
+	state, err := s.State()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	s.Close()
+
+	// The rest should be read exactly once
+	s = newScanner()
+	if err := s.SetState(state); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	for {
+		server, err := s.ReadServer()
+		if err != nil {
+			break
+		}
+		if seen[server.GetIP().String()] {
+			t.Errorf("Read %s again after resuming", server.GetIP())
+		}
+		seen[server.GetIP().String()] = true
+	}
+	if len(seen) != 64 {
+		t.Errorf("Expected 64 servers, got %d", len(seen))
+	}
+}
@@ -2,10 +2,12 @@ package serverreaders
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
 	"io"
+	"math"
 	"net"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/vertoforce/genericenricher"
@@ -14,6 +16,11 @@ import (
 
 const (
 	defaultPortScanTimeout = time.Second * 3
+	defaultProbeWorkers    = 64
+	// DefaultMaxTargets Default maximum number of ip/port pairs to scan, every IPv4 address on 16 ports
+	DefaultMaxTargets = uint64(1) << 36
+	// largeNetSize Networks with more ips than this get a warning in the scan plan, the size of an IPv4 /8
+	largeNetSize = uint64(1) << 24
 )
 
 // IPWithPort IP address with a port
@@ -22,26 +29,67 @@ type IPWithPort struct {
 	Port int
 }
 
-// Scanner struct
+// Scanner struct.  ReadServer, Close, Reset, State and SetState are safe to call from multiple goroutines,
+// but the exported fields must not be changed once reading starts
 type Scanner struct {
 	Nets          []net.IPNet
-	Ports         []int // Ports to scan
+	Hosts         []string    // Hostnames to scan, see AddHost
+	Exclusions    []net.IPNet // IPs in Nets to skip, see AddExclusion
+	Ports         []int       // Ports to scan
 	Timeout       time.Duration
 	CheckPortOpen bool // Only return servers that have the port open
+	// Detect the type of server and if it uses TLS on each open port, see FingerprintTarget.
+	// This implies CheckPortOpen.  Servers are returned as *FingerprintedServer, and the detected
+	// type is used unless SetServerType was called.  Ports detected as SSH are skipped, as SSH servers can't be searched
+	Fingerprint bool
+	// Number of ports to check at once when CheckPortOpen is set
+	ProbeWorkers int
+	// Maximum new connections per second when checking ports, 0 for no limit
+	ProbeRate float64
+	// Maximum sockets open at once when checking ports, 0 to only be limited by ProbeWorkers
+	MaxInFlight int
+	// Refuse to scan more ip/port pairs than this, 0 for no limit.
+	// There can never be more than 2^64-1 pairs, so a whole IPv6 /64 can't be scanned
+	MaxTargets uint64
+	// Scan ip/port pairs across all networks in a pseudo-random order, instead of each ip in order with each port
+	Randomize bool
+	// Seed of the random order, the same seed gives the same order.  If 0 a seed is picked when reading starts
+	Seed int64
+	// Resolves Hosts, defaults to net.DefaultResolver
+	Resolver Resolver
+	// Skip hostnames that resolve to an ip in Nets or to the same ip as another hostname
+	DedupeHosts bool
 
-	serverType  enrichers.ServerType
-	readCtx     context.Context
-	readCancel  context.CancelFunc
-	ipsWithPort chan IPWithPort
+	readLock        sync.Mutex // Held while reading
+	results         chan probeResult
+	stateLock       sync.Mutex // Protects the fields below, never held while waiting for a probe
+	serverType      enrichers.ServerType
+	readCancel      context.CancelFunc
+	closed          bool
+	total           uint64          // Number of ip/port pairs, once reading starts
+	position        uint64          // Index of the first ip/port pair not read yet
+	done            map[uint64]bool // Pairs after position that are already read, when probing out of order
+	dial            dialFunc
+	unresolved      []string // Hosts that could not be resolved
+	connectResolver Resolver // Resolver servers of hostnames connect with, net.DefaultResolver if nil
+}
+
+// scannerState Position of a scanner, see State
+type scannerState struct {
+	Position uint64
+	Done     []uint64 `json:",omitempty"` // Pairs after Position already read
+	Seed     int64    `json:",omitempty"` // Seed of the random order, so it continues in the same order
 }
 
 // NewScanner Create new scanner to scan ips for ports
 func NewScanner() *Scanner {
-	return &Scanner{Timeout: defaultPortScanTimeout}
+	return &Scanner{Timeout: defaultPortScanTimeout, MaxTargets: DefaultMaxTargets, ProbeWorkers: defaultProbeWorkers}
 }
 
 // SetServerType Set type of server if it is known
 func (s *Scanner) SetServerType(t enrichers.ServerType) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
 	s.serverType = t
 }
 
@@ -55,72 +103,210 @@ func (s *Scanner) AddPort(port int) {
 	s.Ports = append(s.Ports, port)
 }
 
-// ReadServer Read next server with open port
+// ReadServer Read next server with open port.  With CheckPortOpen, servers are returned in the order their ports are found open.
+// Returns an error if there are too many ip/port pairs to scan, see Plan
 func (s *Scanner) ReadServer() (genericenricher.Server, error) {
-	if s.ipsWithPort == nil {
-		s.readCtx, s.readCancel = context.WithCancel(context.Background())
-		s.ipsWithPort = s.GetIPsWithPort(s.readCtx)
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	s.stateLock.Lock()
+	closed := s.closed
+	s.stateLock.Unlock()
+	if closed {
+		return nil, io.EOF
+	}
+
+	if s.results == nil {
+		if _, err := s.Plan(); err != nil {
+			return nil, err
+		}
+		s.stateLock.Lock()
+		if s.Randomize && s.Seed == 0 {
+			s.Seed = time.Now().UnixNano()
+		}
+		if s.done == nil {
+			s.done = map[uint64]bool{}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.readCancel = cancel
+		position, done := s.position, copyIndexes(s.done)
+		s.stateLock.Unlock()
+		var total uint64
+		s.results, total = s.probeFrom(ctx, position, done, s.resolveHosts(ctx))
+		s.stateLock.Lock()
+		s.total = total
+		s.stateLock.Unlock()
 	}
 
 	for {
-		if ipWithPort, ok := <-s.ipsWithPort; ok {
+		if result, ok := <-s.results; ok {
+			s.stateLock.Lock()
+			s.markDone(result.index)
+			serverType := s.serverType
+			s.stateLock.Unlock()
+
 			// Check if server has open port
-			if s.CheckPortOpen && !portOpen(ipWithPort.IP, ipWithPort.Port, s.Timeout) {
+			if !result.open {
 				// Not of interest, skip
 				continue
 			}
 
+			// Use the detected type of server if we don't know it
+			tls := false
+			if result.fingerprint != nil {
+				tls = result.fingerprint.TLS
+				if serverType == enrichers.Unknown {
+					serverType = result.fingerprint.ServerType
+				}
+			}
+
 			// Create genericenricher.Server
-			var server genericenricher.Server
-			var err error
-			if s.serverType == enrichers.Unknown {
-				server, err = genericenricher.GetServer(fmt.Sprintf("%s:%d", ipWithPort.IP.String(), ipWithPort.Port))
-			} else {
-				server, err = genericenricher.GetServerWithType(enrichers.GetConnectionString(ipWithPort.IP, ipWithPort.Port, s.serverType), s.serverType)
+			host := result.host
+			if host == "" {
+				host = result.ip.String()
 			}
+			t := target{host: host, port: result.port, serverType: serverType, tls: tls}
+			server, err := getServer(t.connectionString(), serverType)
 			if err != nil {
-				// Failed to create server, continue
+				// Failed to create server, such as for SSH which is not supported, continue
 				continue
 			}
+			if result.host != "" {
+				// Check the exclusions again when connecting, as the server resolves the hostname itself
+				resolver := s.connectResolver
+				if resolver == nil {
+					resolver = net.DefaultResolver
+				}
+				server = &HostServer{Server: server, Host: result.host, IP: result.ip, exclusions: s.Exclusions, resolver: resolver}
+			}
+			if result.fingerprint != nil {
+				return &FingerprintedServer{Server: server, Fingerprint: result.fingerprint}, nil
+			}
 
 			// Return this server
 			return server, nil
 		}
 
-		// No more ipsWithPort, EOF
-		s.readCancel()
+		// No more ip/port pairs, EOF
+		s.Close()
 		return nil, io.EOF
 	}
 }
 
-// Close reading of ips
+// markDone Mark the ip/port pair at index as read, moving the position past every pair that is read.
+// Must hold stateLock
+func (s *Scanner) markDone(index uint64) {
+	s.done[index] = true
+	for s.done[s.position] {
+		delete(s.done, s.position)
+		s.position++
+	}
+}
+
+// Close reading of ips.  A ReadServer waiting for a probe returns once the probes stop
 func (s *Scanner) Close() error {
-	s.readCancel()
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.closed = true
+	if s.readCancel != nil {
+		s.readCancel()
+	}
 	return nil
 }
 
 // Reset back to start of ips
 func (s *Scanner) Reset() error {
+	return s.restart(0, nil, 0)
+}
+
+// restart Stop reading and continue from position, skipping the pairs in done, in the order of seed if not 0
+func (s *Scanner) restart(position uint64, done map[uint64]bool, seed int64) error {
 	s.Close()
-	s.ipsWithPort = nil
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+	s.results = nil
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.readCancel = nil
+	s.closed = false
+	s.total = 0
+	s.position = position
+	s.done = done
+	if seed != 0 {
+		s.Seed = seed
+	}
 	return nil
 }
 
-// GetIPsWithPort Get all ips with port based on networks to scan and ports to scan
+// Progress Get the number of ip/port pairs read and the total number to read.
+// Until reading starts the hostnames are not resolved, so the total counts every hostname
+func (s *Scanner) Progress() (read, total uint64) {
+	s.stateLock.Lock()
+	read, total = s.position+uint64(len(s.done)), s.total
+	s.stateLock.Unlock()
+
+	if total == 0 {
+		if targets := s.TargetCount(); targets.IsUint64() {
+			total = targets.Uint64()
+		}
+	}
+	return read, total
+}
+
+// State Get position of the scanner, to continue from later with SetState
+func (s *Scanner) State() ([]byte, error) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	state := scannerState{Position: s.position}
+	for index := range s.done {
+		state.Done = append(state.Done, index)
+	}
+	sort.Slice(state.Done, func(i, j int) bool { return state.Done[i] < state.Done[j] })
+	if s.Randomize {
+		state.Seed = s.Seed
+	}
+	return json.Marshal(state)
+}
+
+// SetState Continue scanning from a position returned by State.
+// The scanner must have the same Nets, Hosts, Exclusions, Ports and Randomize as when State was called,
+// and the Hosts should resolve the same way
+func (s *Scanner) SetState(state []byte) error {
+	scannerState := scannerState{}
+	if err := json.Unmarshal(state, &scannerState); err != nil {
+		return err
+	}
+	done := map[uint64]bool{}
+	for _, index := range scannerState.Done {
+		if index > scannerState.Position {
+			done[index] = true
+		}
+	}
+	return s.restart(scannerState.Position, done, scannerState.Seed)
+}
+
+// GetIPsWithPort Get all ips with port based on networks to scan and ports to scan, in random order if Randomize is set
 func (s *Scanner) GetIPsWithPort(ctx context.Context) chan IPWithPort {
+	return s.getIPsWithPortFrom(ctx, 0)
+}
+
+// getIPsWithPortFrom Get all ips with port starting at the ip/port pair index start
+func (s *Scanner) getIPsWithPortFrom(ctx context.Context, start uint64) chan IPWithPort {
 	ret := make(chan IPWithPort)
+	ranges := s.ranges()
+	ports := append([]int{}, s.Ports...)
+	count := targetCount(ranges, len(ports))
+	order := s.order(count)
 
 	go func() {
 		defer close(ret)
 
-		ips := s.GetIPs(ctx)
-		for ip := range ips {
-			for _, port := range s.Ports {
-				select {
-				case ret <- IPWithPort{IP: ip, Port: port}:
-				case <-ctx.Done():
-					return
-				}
+		for i := start; i < count; i++ {
+			select {
+			case ret <- ipWithPortAt(ranges, ports, order(i)):
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -128,24 +314,21 @@ func (s *Scanner) GetIPsWithPort(ctx context.Context) chan IPWithPort {
 	return ret
 }
 
-// GetIPs Get channel of all IPs in all networks
+// GetIPs Get channel of all IPs in all networks, except excluded IPs, in random order if Randomize is set
 func (s *Scanner) GetIPs(ctx context.Context) chan net.IP {
 	ips := make(chan net.IP)
+	ranges := s.ranges()
+	count := ipCount(ranges)
+	order := s.order(count)
 
 	go func() {
 		defer close(ips)
 
-		for _, n := range s.Nets {
-			// Loop through each ip in this CIDR
-			baseIP := n.IP
-			for ip := baseIP.Mask(n.Mask); n.Contains(ip); inc(ip) {
-				new := make(net.IP, len(ip))
-				copy(new, ip)
-				select {
-				case ips <- new:
-				case <-ctx.Done():
-					return
-				}
+		for i := uint64(0); i < count; i++ {
+			select {
+			case ips <- ipAt(ranges, order(i)):
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -153,27 +336,53 @@ func (s *Scanner) GetIPs(ctx context.Context) chan net.IP {
 	return ips
 }
 
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
+// order Get the index to read at each position of n indexes
+func (s *Scanner) order(n uint64) func(uint64) uint64 {
+	if !s.Randomize {
+		return func(i uint64) uint64 { return i }
 	}
+	s.stateLock.Lock()
+	seed := s.Seed
+	s.stateLock.Unlock()
+	return newPermutation(n, seed).at
 }
 
-func portOpen(ip net.IP, port int, timeout time.Duration) bool {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip.String(), port), timeout)
+// ipWithPortAt Get the ip/port pair at index i, looping over each port for each ip
+func ipWithPortAt(ranges []ipRange, ports []int, i uint64) IPWithPort {
+	portCount := uint64(len(ports))
+	return IPWithPort{IP: ipAt(ranges, i/portCount), Port: ports[i%portCount]}
+}
 
-	if err != nil {
-		// Check if we have too many connections
-		if strings.Contains(err.Error(), "too many open files") {
-			time.Sleep(timeout)
-			return portOpen(ip, port, timeout)
+// ipAt Get the ip at index i across all ranges
+func ipAt(ranges []ipRange, i uint64) net.IP {
+	for _, r := range ranges {
+		size := r.sizeUint64()
+		if i < size {
+			return r.ipAt(i)
 		}
-		return false
+		i -= size
 	}
+	return nil
+}
+
+// ipCount Number of ips in all ranges, or math.MaxUint64 if there are more
+func ipCount(ranges []ipRange) uint64 {
+	count := uint64(0)
+	for _, r := range ranges {
+		size := r.sizeUint64()
+		if count+size < count {
+			return math.MaxUint64
+		}
+		count += size
+	}
+	return count
+}
 
-	conn.Close()
-	return true
+// targetCount Number of ip/port pairs, or math.MaxUint64 if there are more
+func targetCount(ranges []ipRange, ports int) uint64 {
+	ips := ipCount(ranges)
+	if ports != 0 && ips > math.MaxUint64/uint64(ports) {
+		return math.MaxUint64
+	}
+	return ips * uint64(ports)
 }
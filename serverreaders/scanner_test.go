@@ -95,3 +95,74 @@ func TestGetIPs(t *testing.T) {
 		t.Errorf("Did not loop over all ips")
 	}
 }
+
+func TestScannerState(t *testing.T) {
+	newScanner := func() *Scanner {
+		s := NewScanner()
+		s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.IPMask{255, 255, 255, 252}})
+		s.AddIPNet(net.IPNet{IP: net.IP{10, 0, 1, 0}, Mask: net.IPMask{255, 255, 255, 254}})
+		s.AddPort(80)
+		s.AddPort(443)
+		s.SetServerType(enrichers.HTTP)
+		return s
+	}
+
+	// Read all servers
+	all := []string{}
+	s := newScanner()
+	for {
+		server, err := s.ReadServer()
+		if err != nil {
+			break
+		}
+		all = append(all, server.GetConnectString())
+	}
+	if len(all) != 12 {
+		t.Errorf("Expected 12 servers, got %d", len(all))
+		return
+	}
+
+	// Read some, then continue from the state in a new scanner
+	s = newScanner()
+	got := []string{}
+	for i := 0; i < 5; i++ {
+		server, err := s.ReadServer()
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		got = append(got, server.GetConnectString())
+	}
+	if read, total := s.Progress(); read != 5 || total != 12 {
+		t.Errorf("Wrong progress %d/%d", read, total)
+	}
+	state, err := s.State()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	s.Close()
+
+	s = newScanner()
+	if err := s.SetState(state); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if read, total := s.Progress(); read != 5 || total != 12 {
+		t.Errorf("Wrong progress %d/%d after SetState", read, total)
+	}
+	for {
+		server, err := s.ReadServer()
+		if err != nil {
+			break
+		}
+		got = append(got, server.GetConnectString())
+	}
+
+	if strings.Join(got, ",") != strings.Join(all, ",") {
+		t.Errorf("Resumed scanner did not continue in order: %v", got)
+	}
+	if read, total := s.Progress(); read != 12 || total != 12 {
+		t.Errorf("Wrong progress %d/%d after reading", read, total)
+	}
+}
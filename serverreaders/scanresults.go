@@ -0,0 +1,339 @@
+package serverreaders
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// target Open port on a host
+type target struct {
+	host       string
+	port       int
+	serverType enrichers.ServerType
+	tls        bool
+}
+
+// connectionString Get the connection string of the target, using https for HTTP over TLS
+func (t target) connectionString() string {
+	s := connectionString(t.host, t.port, t.serverType)
+	if t.tls && strings.HasPrefix(s, "http://") {
+		s = "https://" + strings.TrimPrefix(s, "http://")
+	}
+	return s
+}
+
+// ScanResultReader Reads servers from the results of a port scanner such as nmap, masscan, or zmap.  Implements ServerReader
+// and is safe to use from multiple goroutines
+type ScanResultReader struct {
+	lock       sync.Mutex // Protects the fields below
+	targets    []target
+	index      int
+	serverType enrichers.ServerType
+}
+
+// SetServerType Set type of server for open ports where the scanner did not detect the service
+func (r *ScanResultReader) SetServerType(serverType enrichers.ServerType) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.serverType = serverType
+}
+
+// Len Number of open ports in the results
+func (r *ScanResultReader) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.targets)
+}
+
+// Progress Get the number of open ports read and the number in the results
+func (r *ScanResultReader) Progress() (read, total uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return uint64(r.index), uint64(len(r.targets))
+}
+
+// ReadServer Read next server with an open port.  Open ports we can't create a server for are skipped
+func (r *ScanResultReader) ReadServer() (genericenricher.Server, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for r.index < len(r.targets) {
+		t := r.targets[r.index]
+		r.index++
+
+		if t.serverType == enrichers.Unknown {
+			t.serverType = r.serverType
+		}
+		server, err := getServer(t.connectionString(), t.serverType)
+		if err != nil {
+			// Failed to create server, continue
+			continue
+		}
+		return server, nil
+	}
+
+	return nil, io.EOF
+}
+
+// Close stop reading servers
+func (r *ScanResultReader) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.index = len(r.targets)
+	return nil
+}
+
+// Reset start reading from the first open port again
+func (r *ScanResultReader) Reset() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.index = 0
+	return nil
+}
+
+// openResults Open file and parse it with parse
+func openResults(filename string, parse func(io.Reader) (*ScanResultReader, error)) (*ScanResultReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parse(file)
+}
+
+// serviceServerType Get server type and if TLS is used from the name and product of a service detected by a scanner
+func serviceServerType(name, product, tunnel string) (enrichers.ServerType, bool) {
+	name = strings.ToLower(name)
+	tls := tunnel == "ssl" || name == "https" || name == "ssl/http"
+
+	if strings.Contains(strings.ToLower(product), "elasticsearch") || name == "elasticsearch" {
+		return enrichers.ELK, tls
+	}
+	switch name {
+	case "http", "https", "ssl/http", "http-proxy", "http-alt":
+		return enrichers.HTTP, tls
+	case "ftp":
+		return enrichers.FTP, tls
+	case "mysql":
+		return enrichers.SQL, tls
+	case "ssh":
+		return enrichers.SSH, tls
+	default:
+		return enrichers.Unknown, tls
+	}
+}
+
+// -- nmap --
+
+type nmapRun struct {
+	Hosts []nmapHost `xml:"host"`
+}
+
+type nmapHost struct {
+	Addresses []struct {
+		Addr     string `xml:"addr,attr"`
+		AddrType string `xml:"addrtype,attr"`
+	} `xml:"address"`
+	Ports []nmapPort `xml:"ports>port"`
+}
+
+type nmapPort struct {
+	Protocol string `xml:"protocol,attr"`
+	PortID   int    `xml:"portid,attr"`
+	State    struct {
+		State string `xml:"state,attr"`
+	} `xml:"state"`
+	Service struct {
+		Name    string `xml:"name,attr"`
+		Product string `xml:"product,attr"`
+		Tunnel  string `xml:"tunnel,attr"`
+	} `xml:"service"`
+}
+
+// NewNmapReader Read open TCP ports from nmap XML output (nmap -oX).
+// The service detected by nmap (nmap -sV) is used to pick the type of server
+func NewNmapReader(reader io.Reader) (*ScanResultReader, error) {
+	run := nmapRun{}
+	if err := xml.NewDecoder(reader).Decode(&run); err != nil {
+		return nil, fmt.Errorf("invalid nmap xml: %v", err)
+	}
+
+	r := &ScanResultReader{}
+	for _, host := range run.Hosts {
+		ip := ""
+		for _, address := range host.Addresses {
+			if address.AddrType == "ipv4" || address.AddrType == "ipv6" {
+				ip = address.Addr
+				break
+			}
+		}
+		if ip == "" {
+			continue
+		}
+
+		for _, port := range host.Ports {
+			if port.Protocol != "tcp" || port.State.State != "open" {
+				continue
+			}
+			serverType, tls := serviceServerType(port.Service.Name, port.Service.Product, port.Service.Tunnel)
+			r.targets = append(r.targets, target{host: ip, port: port.PortID, serverType: serverType, tls: tls})
+		}
+	}
+
+	return r, nil
+}
+
+// NewNmapReaderFromFile Read open TCP ports from a nmap XML file, see NewNmapReader
+func NewNmapReaderFromFile(filename string) (*ScanResultReader, error) {
+	return openResults(filename, NewNmapReader)
+}
+
+// -- masscan --
+
+type masscanHost struct {
+	IP    string `json:"ip"`
+	Ports []struct {
+		Port    int    `json:"port"`
+		Proto   string `json:"proto"`
+		Status  string `json:"status"`
+		Service struct {
+			Name string `json:"name"`
+		} `json:"service"`
+	} `json:"ports"`
+}
+
+// NewMasscanReader Read open TCP ports from masscan JSON output (masscan -oJ).
+// This also reads output of older versions of masscan that is not quite valid JSON
+func NewMasscanReader(reader io.Reader) (*ScanResultReader, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []masscanHost{}
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		// Older versions of masscan leave a trailing comma, so parse each line on its own
+		hosts = hosts[:0]
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			text = strings.TrimSuffix(text, ",")
+			if !strings.HasPrefix(text, "{") || !strings.Contains(text, `"ip"`) {
+				continue
+			}
+			host := masscanHost{}
+			if err := json.Unmarshal([]byte(text), &host); err != nil {
+				return nil, fmt.Errorf("invalid masscan json on line %d: %v", line, err)
+			}
+			hosts = append(hosts, host)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &ScanResultReader{}
+	for _, host := range hosts {
+		for _, port := range host.Ports {
+			if port.Proto != "tcp" || (port.Status != "" && port.Status != "open") {
+				continue
+			}
+			serverType, tls := serviceServerType(port.Service.Name, "", "")
+			r.targets = append(r.targets, target{host: host.IP, port: port.Port, serverType: serverType, tls: tls})
+		}
+	}
+
+	return r, nil
+}
+
+// NewMasscanReaderFromFile Read open TCP ports from a masscan JSON file, see NewMasscanReader
+func NewMasscanReaderFromFile(filename string) (*ScanResultReader, error) {
+	return openResults(filename, NewMasscanReader)
+}
+
+// -- zmap --
+
+// NewZmapReader Read hosts from zmap CSV output (zmap -O csv).
+// If the output has a header with a sport field it is used as the port, otherwise port is used.
+// If the output has success or classification fields, only successful synack responses are read
+func NewZmapReader(reader io.Reader, port int) (*ScanResultReader, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.Comment = '#'
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid zmap csv: %v", err)
+	}
+
+	// Find columns from header
+	columns := map[string]int{"saddr": 0}
+	if len(records) > 0 && net.ParseIP(records[0][0]) == nil {
+		columns = map[string]int{}
+		for i, name := range records[0] {
+			columns[strings.TrimSpace(name)] = i
+		}
+		records = records[1:]
+		if _, ok := columns["saddr"]; !ok {
+			return nil, errors.New("zmap csv header has no saddr field")
+		}
+	}
+	field := func(record []string, name string) (string, bool) {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return "", false
+		}
+		return strings.TrimSpace(record[i]), true
+	}
+
+	r := &ScanResultReader{}
+	for i, record := range records {
+		if success, ok := field(record, "success"); ok && success != "1" && success != "true" {
+			continue
+		}
+		if classification, ok := field(record, "classification"); ok && classification != "synack" {
+			continue
+		}
+
+		ip, _ := field(record, "saddr")
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid ip `%s` in zmap csv record %d", ip, i+1)
+		}
+		targetPort := port
+		if sport, ok := field(record, "sport"); ok {
+			targetPort, err = strconv.Atoi(sport)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port `%s` in zmap csv record %d", sport, i+1)
+			}
+		}
+		if targetPort < 1 || targetPort > 65535 {
+			return nil, errors.New("zmap csv has no sport field, and no port was given")
+		}
+		r.targets = append(r.targets, target{host: ip, port: targetPort})
+	}
+
+	return r, nil
+}
+
+// NewZmapReaderFromFile Read hosts from a zmap CSV file, see NewZmapReader
+func NewZmapReaderFromFile(filename string, port int) (*ScanResultReader, error) {
+	return openResults(filename, func(reader io.Reader) (*ScanResultReader, error) {
+		return NewZmapReader(reader, port)
+	})
+}
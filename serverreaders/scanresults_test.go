@@ -0,0 +1,100 @@
+package serverreaders
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// readConnectStrings Read all servers and get their connect strings and types
+func readConnectStrings(t *testing.T, r *ScanResultReader) []string {
+	servers := []string{}
+	for {
+		server, err := r.ReadServer()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf(err.Error())
+			break
+		}
+		servers = append(servers, server.GetConnectString()+" "+server.Type().String())
+	}
+	return servers
+}
+
+func TestNmapReader(t *testing.T) {
+	r, err := NewNmapReaderFromFile(filepath.Join("testdata", "nmap.xml"))
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if r.Len() != 6 {
+		t.Errorf("Expected 6 open tcp ports, got %d", r.Len())
+	}
+
+	// SSH has no enricher, and 5601 has no service detected
+	expected := "http://10.0.0.1:80 HTTP,https://10.0.0.1:443 HTTP,http://10.0.0.1:9200 ELK,ftp://10.0.0.2:21 FTP"
+	if got := strings.Join(readConnectStrings(t, r), ","); got != expected {
+		t.Errorf("Got %s", got)
+	}
+
+	// Check default server type and reset
+	r.SetServerType(enrichers.HTTP)
+	r.Reset()
+	servers := readConnectStrings(t, r)
+	if len(servers) != 5 || servers[4] != "http://10.0.0.2:5601 HTTP" {
+		t.Errorf("Did not use default server type: %v", servers)
+	}
+
+	if _, err := NewNmapReader(strings.NewReader("<nmaprun>")); err == nil {
+		t.Errorf("Should fail on invalid xml")
+	}
+}
+
+func TestMasscanReader(t *testing.T) {
+	for _, file := range []string{"masscan.json", "masscan_old.json"} {
+		r, err := NewMasscanReaderFromFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		r.SetServerType(enrichers.HTTP)
+		servers := readConnectStrings(t, r)
+		if len(servers) < 2 || servers[0] != "http://10.0.0.1:9200 HTTP" || servers[1] != "http://10.0.0.2:80 HTTP" {
+			t.Errorf("%s: got %v", file, servers)
+		}
+	}
+}
+
+func TestZmapReader(t *testing.T) {
+	r, err := NewZmapReaderFromFile(filepath.Join("testdata", "zmap.csv"), 0)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	r.SetServerType(enrichers.ELK)
+	expected := "http://10.0.0.1:9200 ELK,http://10.0.0.3:9200 ELK"
+	if got := strings.Join(readConnectStrings(t, r), ","); got != expected {
+		t.Errorf("Got %s", got)
+	}
+
+	// Just ip addresses
+	r, err = NewZmapReaderFromFile(filepath.Join("testdata", "zmap_saddr.csv"), 21)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	r.SetServerType(enrichers.FTP)
+	expected = "ftp://10.0.0.1:21 FTP,ftp://10.0.0.5:21 FTP"
+	if got := strings.Join(readConnectStrings(t, r), ","); got != expected {
+		t.Errorf("Got %s", got)
+	}
+
+	if _, err := NewZmapReaderFromFile(filepath.Join("testdata", "zmap_saddr.csv"), 0); err == nil {
+		t.Errorf("Should fail without a port")
+	}
+}
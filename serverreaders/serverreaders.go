@@ -1,2 +1,82 @@
 // Package serverreaders exposes different sources of servers such as shodan
 package serverreaders
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+// serverTypeNames Names of each server type, including common aliases
+var serverTypeNames = map[string]enrichers.ServerType{
+	"elk":           enrichers.ELK,
+	"elastic":       enrichers.ELK,
+	"elasticsearch": enrichers.ELK,
+	"ftp":           enrichers.FTP,
+	"sql":           enrichers.SQL,
+	"mysql":         enrichers.SQL,
+	"http":          enrichers.HTTP,
+	"https":         enrichers.HTTP,
+}
+
+// unsupportedServerTypeNames Names of servers that can't be searched, as genericenricher can't read their data
+var unsupportedServerTypeNames = map[string]bool{
+	"ssh": true,
+}
+
+// ParseServerType Get server type from its name, such as "elk" or "http"
+func ParseServerType(name string) (enrichers.ServerType, error) {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	if serverType, ok := serverTypeNames[lower]; ok {
+		return serverType, nil
+	}
+	if unsupportedServerTypeNames[lower] {
+		return enrichers.Unknown, fmt.Errorf("server type `%s` is not supported", name)
+	}
+	return enrichers.Unknown, fmt.Errorf("unknown server type `%s`", name)
+}
+
+// getServer Create server from a connection string, detecting the type of server if it is unknown
+func getServer(connectionString string, serverType enrichers.ServerType) (genericenricher.Server, error) {
+	if serverType == enrichers.SSH {
+		return nil, fmt.Errorf("server type %s is not supported", serverType)
+	}
+	if serverType == enrichers.Unknown {
+		return genericenricher.GetServer(connectionString)
+	}
+	return genericenricher.GetServerWithType(connectionString, serverType)
+}
+
+// connectionString Get the connection string for a host (ip or hostname), port, and type of server
+func connectionString(host string, port int, serverType enrichers.ServerType) string {
+	hostPort := net.JoinHostPort(host, strconv.Itoa(port))
+	switch serverType {
+	case enrichers.ELK, enrichers.HTTP:
+		return "http://" + hostPort
+	case enrichers.FTP:
+		return "ftp://" + hostPort
+	default:
+		return hostPort
+	}
+}
+
+// ConnectStringHost Get the host (ip or hostname) of a connection string, empty if there isn't one
+func ConnectStringHost(connectString string) string {
+	if strings.Contains(connectString, "://") {
+		u, err := url.Parse(connectString)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+	host, _, err := net.SplitHostPort(connectString)
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,45 @@
+package serverreaders
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vertoforce/genericenricher/enrichers"
+)
+
+func TestParseServerType(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverType enrichers.ServerType
+		err        bool
+	}{
+		{"elk", enrichers.ELK, false},
+		{"Elasticsearch", enrichers.ELK, false},
+		{" HTTP ", enrichers.HTTP, false},
+		{"mysql", enrichers.SQL, false},
+		{"gopher", enrichers.Unknown, true},
+		{"ssh", enrichers.Unknown, true},
+	}
+	for _, test := range tests {
+		serverType, err := ParseServerType(test.name)
+		if serverType != test.serverType || (err != nil) != test.err {
+			t.Errorf("ParseServerType(%q) = %s, %v", test.name, serverType, err)
+		}
+	}
+}
+
+func TestUnsupportedServerType(t *testing.T) {
+	// SSH is recognized, but reported as not supported as it can't be searched
+	if _, err := ParseServerType("SSH"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected ssh to be not supported, got %v", err)
+	}
+	if _, err := getServer("10.0.0.1:22", enrichers.SSH); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected ssh server to be not supported, got %v", err)
+	}
+
+	f := NewFileReader(strings.NewReader("10.0.0.1:22 ssh\n"))
+	f.Strict = true
+	if _, err := f.ReadServer(); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected ssh line to be not supported, got %v", err)
+	}
+}
@@ -2,9 +2,12 @@ package serverreaders
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ns3777k/go-shodan/shodan"
@@ -17,27 +20,76 @@ const (
 	ShodanELKQuery = "\"Elastic Indices\""
 )
 
+// shodanPageSize Number of results in each page of a shodan query
+const shodanPageSize = 100
+
+// ShodanOptions Options of a ShodanReader
+type ShodanOptions struct {
+	MaxPages  int    // Maximum number of pages of results to read, 0 for all pages
+	MaxHosts  int    // Maximum number of hosts to read, 0 for all hosts
+	StartPage int    // Page of results to start reading from, starting at 1
+	BaseURL   string // URL of the shodan API, defaults to https://api.shodan.io
+}
+
 // ShodanReader Finds servers on shodan based on a query.  Implements ServerReader
+//
+// Pages of results are fetched as they are read, each page past the first uses a query credit.
+// It is safe to use from multiple goroutines, a read fetching a page holds up the others until it is fetched.
+// Close stops fetching a page
 type ShodanReader struct {
-	query            string
-	shodanHosts      []*shodan.HostData
+	query   string
+	options ShodanOptions
+	client  *shodan.Client
+
+	readLock         sync.Mutex // Held while reading, including while fetching a page
+	stateLock        sync.Mutex // Protects the fields below, never held while fetching a page
+	readCtx          context.Context
+	readCancel       context.CancelFunc // Cancels readCtx, to stop fetching a page on Close
+	shodanHosts      []*shodan.HostData // Hosts of the current page
 	shodanHostsIndex int
+	page             int // Current page
+	pagesRead        int // Pages fetched since the start page
+	hostsRead        int
+	total            int // Total results of the query
+	closed           bool
 	serverType       enrichers.ServerType
-	client           *shodan.Client
+	mappings         []ShodanServerTypeMapping // Added with AddServerTypeMapping
+	export           *shodanExport             // Set if reading an export file instead of using the API
+}
+
+// shodanState Position of a ShodanReader, see State
+type shodanState struct {
+	Page      int
+	Index     int
+	PagesRead int
+	HostsRead int
 }
 
 // NewShodan Create new shodan reader based on a shodan query
 func NewShodan(ctx context.Context, query string, token string, timeout time.Duration) (*ShodanReader, error) {
+	return NewShodanWithOptions(ctx, query, token, timeout, ShodanOptions{})
+}
+
+// NewShodanWithOptions Create new shodan reader based on a shodan query, with options to limit the results read
+func NewShodanWithOptions(ctx context.Context, query string, token string, timeout time.Duration, options ShodanOptions) (*ShodanReader, error) {
+	if options.StartPage < 1 {
+		options.StartPage = 1
+	}
 	s := &ShodanReader{}
 	s.query = query
+	s.options = options
 
 	// Make the shodan client
 	httpClient := &http.Client{Timeout: timeout}
 	client := shodan.NewClient(httpClient, token)
+	if options.BaseURL != "" {
+		client.BaseURL = strings.TrimSuffix(options.BaseURL, "/")
+	}
 	s.client = client
 
 	// Make query
-	err := s.Reset()
+	s.reset()
+	err := s.fetchPage(ctx, s.options.StartPage)
 	if err != nil {
 		return nil, err
 	}
@@ -45,67 +97,324 @@ func NewShodan(ctx context.Context, query string, token string, timeout time.Dur
 	return s, nil
 }
 
-// SetServerType If the type of servers this will return is already known, set it using this function
+// SetServerType If the type of servers this will return is already known, set it using this function.
+// Otherwise the type is taken from the product and module of each shodan result, see AddServerTypeMapping
 func (s *ShodanReader) SetServerType(serverType enrichers.ServerType) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
 	s.serverType = serverType
 }
 
-// ReadServer Gets next server from Shodan
-func (s *ShodanReader) ReadServer() (server genericenricher.Server, err error) {
-	// Check if we read all servers
-	if s.shodanHostsIndex == len(s.shodanHosts) {
+// Total Get the total number of results of the query, which can be more than will be read.
+// This is 0 when reading an export file
+func (s *ShodanReader) Total() int {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.total
+}
+
+// Progress Get the number of hosts read and the number that will be read, from the total results of the query
+// and the MaxPages and MaxHosts options.  The total is 0 when reading an export file, as it is not known
+func (s *ShodanReader) Progress() (read, total uint64) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.export == nil {
+		hosts := s.total - (s.options.StartPage-1)*shodanPageSize
+		if s.options.MaxPages > 0 && hosts > s.options.MaxPages*shodanPageSize {
+			hosts = s.options.MaxPages * shodanPageSize
+		}
+		if s.options.MaxHosts > 0 && hosts > s.options.MaxHosts {
+			hosts = s.options.MaxHosts
+		}
+		if hosts > 0 {
+			total = uint64(hosts)
+		}
+	}
+	return uint64(s.hostsRead), total
+}
+
+// ReadServer Gets next server from Shodan, fetching the next page of results when needed
+func (s *ShodanReader) ReadServer() (genericenricher.Server, error) {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	for {
+		shodanHost, err := s.nextHost()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.EqualFold(shodanHost.Transport, "udp") {
+			// We can only connect over TCP
+			continue
+		}
+
+		// Create server off this, using the type from the shodan result if we don't know it
+		s.stateLock.Lock()
+		serverType := s.serverType
+		if serverType == enrichers.Unknown {
+			serverType = s.shodanServerType(shodanHost)
+		}
+		s.stateLock.Unlock()
+		server, err := getServer(shodanConnectionString(shodanHost, serverType), serverType)
+		if err != nil {
+			// Failed to create server, such as for SSH which is not supported, continue
+			continue
+		}
+
+		return server, nil
+	}
+}
+
+// nextHost Get the next host of the query results or export file.  Must hold readLock, stateLock is released while fetching a page
+func (s *ShodanReader) nextHost() (*shodan.HostData, error) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.closed || (s.options.MaxHosts > 0 && s.hostsRead >= s.options.MaxHosts) {
 		return nil, io.EOF
 	}
+	if s.export != nil {
+		shodanHost, err := s.export.next()
+		if err != nil {
+			return nil, err
+		}
+		s.hostsRead++
+		return shodanHost, nil
+	}
+
+	// Check if we read all hosts of this page
+	if s.shodanHostsIndex == len(s.shodanHosts) {
+		if !s.morePages() {
+			return nil, io.EOF
+		}
+		ctx, page := s.readCtx, s.page+1
+		s.stateLock.Unlock()
+		err := s.fetchPage(ctx, page)
+		s.stateLock.Lock()
+		if s.closed {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(s.shodanHosts) == 0 {
+			return nil, io.EOF
+		}
+	}
 
-	// Return next host we have
 	shodanHost := s.shodanHosts[s.shodanHostsIndex]
 	s.shodanHostsIndex++
+	s.hostsRead++
+	return shodanHost, nil
+}
 
-	connectionString := shodanGetConnectionURL(shodanHost)
-
-	// Create server off this
-	if s.serverType == enrichers.Unknown {
-		// Try to get server type
-		server, err = genericenricher.GetServer(connectionString)
-	} else {
-		// Use known server type
-		server, err = genericenricher.GetServerWithType(connectionString, s.serverType)
+// morePages Check if there is another page of results we should read.  Must hold stateLock
+func (s *ShodanReader) morePages() bool {
+	if len(s.shodanHosts) == 0 || s.page*shodanPageSize >= s.total {
+		return false
 	}
+	return s.options.MaxPages <= 0 || s.pagesRead < s.options.MaxPages
+}
+
+// fetchPage Get a page of results.  Must not hold stateLock
+func (s *ShodanReader) fetchPage(ctx context.Context, page int) error {
+	matchedHosts, err := s.client.GetHostsForQuery(ctx, &shodan.HostQueryOptions{Query: s.query, Page: page})
 	if err != nil {
-		// TODO: Change this to just get the next server
-		return nil, err
+		return err
 	}
-
-	return server, nil
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.shodanHosts = matchedHosts.Matches
+	s.shodanHostsIndex = 0
+	s.total = matchedHosts.Total
+	s.page = page
+	s.pagesRead++
+	return nil
 }
 
-// Close shodan server reader
+// Close shodan server reader, stopping a read fetching a page
 func (s *ShodanReader) Close() error {
-	s.shodanHostsIndex = len(s.shodanHosts)
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.closed = true
+	if s.readCancel != nil {
+		s.readCancel()
+	}
+	if s.export != nil {
+		return s.export.close()
+	}
 	return nil
 }
 
-// Reset make shodan query again and restart processing of hosts
-func (s *ShodanReader) Reset() error {
+// reset Clear position in the results.  Must hold stateLock
+func (s *ShodanReader) reset() {
+	s.shodanHosts = nil
 	s.shodanHostsIndex = 0
+	s.pagesRead = 0
+	s.hostsRead = 0
+	s.open()
+}
 
-	// TODO: pagination?
-	ctx, cancel := context.WithTimeout(context.Background(), s.client.Client.Timeout)
-	matchedHosts, err := s.client.GetHostsForQuery(ctx, &shodan.HostQueryOptions{Query: s.query})
-	cancel()
-	if err != nil {
+// open Start reading after Close, with a new context to fetch pages with.  Must hold stateLock
+func (s *ShodanReader) open() {
+	if s.readCancel != nil {
+		s.readCancel()
+	}
+	s.readCtx, s.readCancel = context.WithCancel(context.Background())
+	s.closed = false
+}
+
+// Reset make shodan query again and restart processing of hosts from the start page, or read the export file again
+func (s *ShodanReader) Reset() error {
+	// Stop a read in progress
+	s.Close()
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+	return s.restart()
+}
+
+// restart Clear position and fetch the start page again, or open the export file again.  Must hold readLock
+func (s *ShodanReader) restart() error {
+	s.stateLock.Lock()
+	s.reset()
+	if s.export != nil {
+		defer s.stateLock.Unlock()
+		return s.export.open()
+	}
+	ctx := s.readCtx
+	s.stateLock.Unlock()
+	return s.fetchPage(ctx, s.options.StartPage)
+}
+
+// State Get position in the query results, to continue from later with SetState
+func (s *ShodanReader) State() ([]byte, error) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return json.Marshal(shodanState{Page: s.page, Index: s.shodanHostsIndex, PagesRead: s.pagesRead, HostsRead: s.hostsRead})
+}
+
+// SetState Continue reading the query results from a position returned by State, fetching that page if needed.
+// Note the results of the query could have changed since State was called
+func (s *ShodanReader) SetState(state []byte) error {
+	shodanState := shodanState{}
+	if err := json.Unmarshal(state, &shodanState); err != nil {
 		return err
 	}
-	s.shodanHosts = matchedHosts.Matches
 
+	// Stop a read in progress
+	s.Close()
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+	if s.export != nil {
+		return s.setExportState(shodanState.HostsRead)
+	}
+	if shodanState.Page == 0 {
+		// State from before pagination, which only read the first page
+		shodanState.Page = 1
+		shodanState.PagesRead = 1
+		shodanState.HostsRead = shodanState.Index
+	}
+
+	s.stateLock.Lock()
+	s.open()
+	ctx, page := s.readCtx, s.page
+	s.stateLock.Unlock()
+	if shodanState.Page != page {
+		if err := s.fetchPage(ctx, shodanState.Page); err != nil {
+			return err
+		}
+	}
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if shodanState.Index < 0 || shodanState.Index > len(s.shodanHosts) {
+		return fmt.Errorf("shodan index %d out of range", shodanState.Index)
+	}
+	s.shodanHostsIndex = shodanState.Index
+	s.pagesRead = shodanState.PagesRead
+	s.hostsRead = shodanState.HostsRead
 	return nil
 }
 
-func shodanGetConnectionURL(shodanHost *shodan.HostData) string {
-	switch shodanHost.Product {
-	case "Elastic":
-		return fmt.Sprintf("http://%s:%d", shodanHost.IP, shodanHost.Port)
-	default:
-		return fmt.Sprintf("http://%s:%d", shodanHost.IP, shodanHost.Port)
+// ShodanServerTypeMapping Type of server for shodan results with a product or module
+type ShodanServerTypeMapping struct {
+	Product    string // Part of the product, ignoring case, or empty to match any product
+	Module     string // Shodan module that found the service, such as "elastic" or "https", or empty to match any module
+	ServerType enrichers.ServerType
+}
+
+// DefaultShodanServerTypeMappings Mappings used by each ShodanReader after the ones added with AddServerTypeMapping.
+// SSH results are mapped so they are skipped rather than guessed to be another type, as SSH servers can't be searched
+var DefaultShodanServerTypeMappings = []ShodanServerTypeMapping{
+	{Product: "elastic", ServerType: enrichers.ELK},
+	{Module: "elastic", ServerType: enrichers.ELK},
+	{Module: "ftp", ServerType: enrichers.FTP},
+	{Product: "ftp", ServerType: enrichers.FTP},
+	{Module: "mysql", ServerType: enrichers.SQL},
+	{Product: "mysql", ServerType: enrichers.SQL},
+	{Product: "mariadb", ServerType: enrichers.SQL},
+	{Module: "ssh", ServerType: enrichers.SSH},
+	{Product: "openssh", ServerType: enrichers.SSH},
+	{Module: "http", ServerType: enrichers.HTTP},
+	{Module: "https", ServerType: enrichers.HTTP},
+	{Module: "http-simple-new", ServerType: enrichers.HTTP},
+	{Module: "https-simple-new", ServerType: enrichers.HTTP},
+}
+
+// matches Check if the mapping applies to a shodan result
+func (mapping ShodanServerTypeMapping) matches(shodanHost *shodan.HostData) bool {
+	if mapping.Product == "" && mapping.Module == "" {
+		return false
+	}
+	if mapping.Product != "" && !strings.Contains(strings.ToLower(shodanHost.Product), strings.ToLower(mapping.Product)) {
+		return false
+	}
+	if mapping.Module != "" && !strings.EqualFold(shodanModule(shodanHost), mapping.Module) {
+		return false
+	}
+	return true
+}
+
+// AddServerTypeMapping Add a mapping from shodan results to a type of server.
+// Mappings added later are checked first, before DefaultShodanServerTypeMappings
+func (s *ShodanReader) AddServerTypeMapping(mapping ShodanServerTypeMapping) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.mappings = append([]ShodanServerTypeMapping{mapping}, s.mappings...)
+}
+
+// shodanServerType Get the type of server of a shodan result using the first mapping that applies
+func (s *ShodanReader) shodanServerType(shodanHost *shodan.HostData) enrichers.ServerType {
+	for _, mappings := range [][]ShodanServerTypeMapping{s.mappings, DefaultShodanServerTypeMappings} {
+		for _, mapping := range mappings {
+			if mapping.matches(shodanHost) {
+				return mapping.ServerType
+			}
+		}
+	}
+	return enrichers.Unknown
+}
+
+// shodanModule Get the shodan module that found the service
+func shodanModule(shodanHost *shodan.HostData) string {
+	module, _ := shodanHost.ShodanData["module"].(string)
+	return module
+}
+
+// shodanTLS Check if the service of a shodan result uses TLS
+func shodanTLS(shodanHost *shodan.HostData) bool {
+	module := strings.ToLower(shodanModule(shodanHost))
+	return shodanHost.SSL != nil || strings.HasPrefix(module, "https")
+}
+
+// shodanConnectionString Get the connection string of a shodan result for a type of server.
+// If the type is unknown http is used so the type can be detected
+func shodanConnectionString(shodanHost *shodan.HostData, serverType enrichers.ServerType) string {
+	t := target{host: shodanHost.IP.String(), port: shodanHost.Port, serverType: serverType, tls: shodanTLS(shodanHost)}
+	if serverType == enrichers.Unknown {
+		t.serverType = enrichers.HTTP
 	}
+	return t.connectionString()
 }
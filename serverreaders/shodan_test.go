@@ -2,9 +2,15 @@ package serverreaders
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -62,3 +68,257 @@ func TestNewShodan(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 }
+
+// newShodanAPI Create a stand in for the shodan API with total results, returns the server and the pages requested
+func newShodanAPI(total int) (*httptest.Server, *[]int) {
+	pages := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/shodan/host/search" || r.URL.Query().Get("key") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "Invalid API key"}`))
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		pages = append(pages, page)
+
+		matches := []map[string]interface{}{}
+		for i := (page - 1) * shodanPageSize; i < page*shodanPageSize && i < total; i++ {
+			matches = append(matches, map[string]interface{}{"ip_str": fmt.Sprintf("10.0.%d.%d", i/256, i%256), "port": 9200})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"total": total, "matches": matches})
+	}))
+	return server, &pages
+}
+
+// readShodanIPs Read the ips of all servers
+func readShodanIPs(t *testing.T, shodanReader *ShodanReader) []string {
+	ips := []string{}
+	for {
+		server, err := shodanReader.ReadServer()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf(err.Error())
+			break
+		}
+		ips = append(ips, server.GetIP().String())
+	}
+	return ips
+}
+
+func TestShodanPagination(t *testing.T) {
+	api, pages := newShodanAPI(250)
+	defer api.Close()
+
+	tests := []struct {
+		options ShodanOptions
+		hosts   int
+		first   string
+		pages   []int
+	}{
+		{ShodanOptions{}, 250, "10.0.0.0", []int{1, 2, 3}},
+		{ShodanOptions{MaxPages: 2}, 200, "10.0.0.0", []int{1, 2}},
+		{ShodanOptions{MaxHosts: 150}, 150, "10.0.0.0", []int{1, 2}},
+		{ShodanOptions{StartPage: 2}, 150, "10.0.0.100", []int{2, 3}},
+		{ShodanOptions{StartPage: 4}, 0, "", []int{4}},
+	}
+	for i, test := range tests {
+		*pages = nil
+		test.options.BaseURL = api.URL
+		shodanReader, err := NewShodanWithOptions(context.Background(), "query", "token", time.Second*5, test.options)
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+		shodanReader.SetServerType(enrichers.ELK)
+		if shodanReader.Total() != 250 {
+			t.Errorf("Wrong total %d", shodanReader.Total())
+		}
+		if len(*pages) != 1 {
+			t.Errorf("%d: Should only fetch the first page before reading", i)
+		}
+		if read, total := shodanReader.Progress(); read != 0 || total != uint64(test.hosts) {
+			t.Errorf("%d: Wrong progress %d/%d before reading", i, read, total)
+		}
+
+		ips := readShodanIPs(t, shodanReader)
+		if len(ips) != test.hosts || (len(ips) > 0 && ips[0] != test.first) {
+			t.Errorf("%d: Read %d hosts starting with %v", i, len(ips), ips)
+		}
+		if read, total := shodanReader.Progress(); read != uint64(test.hosts) || total != uint64(test.hosts) {
+			t.Errorf("%d: Wrong progress %d/%d after reading", i, read, total)
+		}
+		if fmt.Sprint(*pages) != fmt.Sprint(test.pages) {
+			t.Errorf("%d: Fetched pages %v", i, *pages)
+		}
+	}
+
+	// Bad token
+	_, err := NewShodanWithOptions(context.Background(), "query", "bad", time.Second*5, ShodanOptions{BaseURL: api.URL})
+	if err == nil {
+		t.Errorf("Should fail with bad token")
+	}
+}
+
+func TestShodanState(t *testing.T) {
+	api, _ := newShodanAPI(250)
+	defer api.Close()
+	options := ShodanOptions{BaseURL: api.URL, MaxHosts: 200}
+
+	shodanReader, err := NewShodanWithOptions(context.Background(), "query", "token", time.Second*5, options)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	shodanReader.SetServerType(enrichers.ELK)
+	for i := 0; i < 120; i++ {
+		if _, err := shodanReader.ReadServer(); err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+	}
+	state, err := shodanReader.State()
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	// Continue from the state in a new reader
+	shodanReader, err = NewShodanWithOptions(context.Background(), "query", "token", time.Second*5, options)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	shodanReader.SetServerType(enrichers.ELK)
+	if err := shodanReader.SetState(state); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	ips := readShodanIPs(t, shodanReader)
+	if len(ips) != 80 || ips[0] != "10.0.0.120" {
+		t.Errorf("Did not continue from state, read %d hosts", len(ips))
+	}
+
+	// Reset back to the first page
+	if err := shodanReader.Reset(); err != nil {
+		t.Errorf(err.Error())
+	}
+	if ips := readShodanIPs(t, shodanReader); len(ips) != 200 || ips[0] != "10.0.0.0" {
+		t.Errorf("Did not reset, read %d hosts", len(ips))
+	}
+
+	if err := shodanReader.SetState([]byte(`{"Page": 1, "Index": 101}`)); err == nil {
+		t.Errorf("Should fail with index out of range")
+	}
+}
+
+func TestShodanServerTypes(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"total": 6, "matches": [
+			{"ip_str": "10.0.0.1", "port": 9200, "product": "Elastic", "transport": "tcp", "_shodan": {"module": "elastic"}},
+			{"ip_str": "10.0.0.2", "port": 443, "product": "nginx", "transport": "tcp", "ssl": {}, "_shodan": {"module": "https"}},
+			{"ip_str": "10.0.0.3", "port": 21, "product": "vsftpd", "transport": "tcp", "_shodan": {"module": "ftp"}},
+			{"ip_str": "10.0.0.4", "port": 22, "product": "OpenSSH", "transport": "tcp", "_shodan": {"module": "ssh"}},
+			{"ip_str": "10.0.0.5", "port": 9200, "product": "Elastic", "transport": "udp", "_shodan": {"module": "elastic"}},
+			{"ip_str": "10.0.0.6", "port": 8080, "product": "Custom Store", "transport": "tcp", "_shodan": {"module": "custom"}}
+		]}`))
+	}))
+	defer api.Close()
+
+	shodanReader, err := NewShodanWithOptions(context.Background(), "query", "token", time.Second*5, ShodanOptions{BaseURL: api.URL})
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	shodanReader.AddServerTypeMapping(ShodanServerTypeMapping{Product: "custom store", ServerType: enrichers.HTTP})
+
+	// SSH has no enricher, and we can't connect over udp
+	servers := []string{}
+	for {
+		server, err := shodanReader.ReadServer()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf(err.Error())
+			break
+		}
+		servers = append(servers, server.GetConnectString()+" "+server.Type().String())
+	}
+	expected := "http://10.0.0.1:9200 ELK,https://10.0.0.2:443 HTTP,ftp://10.0.0.3:21 FTP,http://10.0.0.6:8080 HTTP"
+	if got := strings.Join(servers, ","); got != expected {
+		t.Errorf("Got %s", got)
+	}
+
+	// Mappings added later take precedence
+	shodanReader.AddServerTypeMapping(ShodanServerTypeMapping{Module: "elastic", ServerType: enrichers.HTTP})
+	shodanReader.Reset()
+	server, err := shodanReader.ReadServer()
+	if err != nil || server.Type() != enrichers.HTTP {
+		t.Errorf("Did not use added mapping")
+	}
+}
+
+func TestNewShodanFromFile(t *testing.T) {
+	expected := []string{"http://10.0.0.1:9200 ELK", "https://10.0.0.2:443 HTTP", "ftp://10.0.0.5:21 FTP"}
+	for _, file := range []string{"shodan_export.json", "shodan_export.json.gz"} {
+		shodanReader, err := NewShodanFromFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+
+		servers := []string{}
+		for {
+			server, err := shodanReader.ReadServer()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf(err.Error())
+				break
+			}
+			servers = append(servers, server.GetConnectString()+" "+server.Type().String())
+		}
+		if strings.Join(servers, ",") != strings.Join(expected, ",") {
+			t.Errorf("%s: got %v", file, servers)
+		}
+
+		// Continue from state after the first two hosts
+		shodanReader.Reset()
+		shodanReader.ReadServer()
+		shodanReader.ReadServer()
+		state, _ := shodanReader.State()
+		shodanReader.Close()
+		if _, err := shodanReader.ReadServer(); err != io.EOF {
+			t.Errorf("Should be EOF after close")
+		}
+		if err := shodanReader.SetState(state); err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+		server, err := shodanReader.ReadServer()
+		if err != nil || server.GetConnectString() != "ftp://10.0.0.5:21" {
+			t.Errorf("%s: Did not continue from state", file)
+		}
+		shodanReader.Close()
+	}
+
+	if _, err := NewShodanFromFile(filepath.Join("testdata", "missing.json")); err == nil {
+		t.Errorf("Should fail on missing file")
+	}
+
+	// Invalid host
+	shodanReader, err := NewShodanFromFile(filepath.Join("testdata", "targets.txt"))
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if _, err := shodanReader.ReadServer(); err == nil || err == io.EOF {
+		t.Errorf("Should fail on invalid json")
+	}
+}
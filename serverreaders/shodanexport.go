@@ -0,0 +1,110 @@
+package serverreaders
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ns3777k/go-shodan/shodan"
+)
+
+// shodanExport Reads hosts from a file downloaded from shodan
+type shodanExport struct {
+	filename string
+	file     *os.File
+	gzip     *gzip.Reader
+	decoder  *json.Decoder
+	read     int // Hosts read from the file
+}
+
+// NewShodanFromFile Create shodan reader of hosts in a file downloaded from shodan (shodan download),
+// instead of making a query.  The file has one host per line as JSON, and can be gzipped (.json.gz)
+func NewShodanFromFile(filename string) (*ShodanReader, error) {
+	export := &shodanExport{filename: filename}
+	if err := export.open(); err != nil {
+		return nil, err
+	}
+	return &ShodanReader{export: export}, nil
+}
+
+// open Open the file, from the start
+func (e *shodanExport) open() error {
+	e.close()
+	file, err := os.Open(e.filename)
+	if err != nil {
+		return err
+	}
+
+	// Check if the file is gzipped
+	var reader io.Reader = bufio.NewReader(file)
+	magic, _ := reader.(*bufio.Reader).Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		e.gzip, err = gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("invalid gzip file %s: %v", e.filename, err)
+		}
+		reader = e.gzip
+	}
+
+	e.file = file
+	e.decoder = json.NewDecoder(reader)
+	e.read = 0
+	return nil
+}
+
+// next Get the next host in the file
+func (e *shodanExport) next() (*shodan.HostData, error) {
+	if e.decoder == nil {
+		return nil, io.EOF
+	}
+	shodanHost := &shodan.HostData{}
+	if err := e.decoder.Decode(shodanHost); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid host %d in %s: %v", e.read+1, e.filename, err)
+	}
+	e.read++
+	return shodanHost, nil
+}
+
+// close Close the file
+func (e *shodanExport) close() error {
+	e.decoder = nil
+	if e.gzip != nil {
+		e.gzip.Close()
+		e.gzip = nil
+	}
+	if e.file != nil {
+		err := e.file.Close()
+		e.file = nil
+		return err
+	}
+	return nil
+}
+
+// setExportState Read the export file again, skipping the hosts read before.  Must hold readLock
+func (s *ShodanReader) setExportState(hostsRead int) error {
+	if hostsRead < 0 {
+		return fmt.Errorf("shodan index %d out of range", hostsRead)
+	}
+	if err := s.restart(); err != nil {
+		return err
+	}
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	for s.hostsRead < hostsRead {
+		if _, err := s.export.next(); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("shodan index %d out of range", hostsRead)
+			}
+			return err
+		}
+		s.hostsRead++
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package serverpatdown
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+	"github.com/vertoforce/serverpatdown/serverreaders"
+)
+
+// StatefulServerReader A ServerReader that can save and restore its position, so a Session can resume part way through it
+type StatefulServerReader interface {
+	ServerReader
+	// State Get the current position of the reader, or nil if it has none, such as a wrapper of a reader without state.
+	// A reader without state is read from the start again on Resume.  It is called before each read, so a Session
+	// taken during a slow read can use it without waiting, and should be quick
+	State() ([]byte, error)
+	// SetState Restore the position of the reader from State.  The next ReadServer continues from that position
+	SetState(state []byte) error
+}
+
+// Session Marshal-able state of a Searcher, used to save and resume processing
+type Session struct {
+	// Rules and options
+	Rules                      []*Rule
+	GetMatchedData             bool
+	ExhaustiveMatching         bool
+	MaxMatchesPerRule          int
+	ReturnNotMatchedServers    bool
+	ReturnReaderErrors         bool
+	ServerDataLimit            int64
+	MatchContextBefore         int
+	MatchContextAfter          int
+	MaxMatchLength             int
+	ServerReaderIterationStyle IterationStyle
+	ServerTimeout              time.Duration
+	Workers                    int
+	Scope                      []string `json:",omitempty"` // Networks in CIDR notation, see Searcher.AddScope
+
+	// Progress
+	Readers   []SessionReader // State of each ServerReader, in the order they were added
+	Processed []SessionServer // Servers already searched, other than those of StatefulServerReaders which resume after them
+	Pending   []SessionServer // Servers read from a ServerReader, but not finished being searched
+	Matches   []SessionMatch  // Matches already returned
+}
+
+// SessionReader State of a ServerReader in a Session
+type SessionReader struct {
+	State    []byte `json:",omitempty"` // Position from StatefulServerReader.State, empty if the reader does not implement it
+	Finished bool   // Reader has no more servers
+}
+
+// SessionServer Server in a Session
+type SessionServer struct {
+	ConnectString string
+	Type          enrichers.ServerType
+}
+
+// SessionMatch Match in a Session
+type SessionMatch struct {
+	Server  SessionServer
+	Matched bool
+	Matches []SessionRuleMatch `json:",omitempty"`
+	Dropped int                `json:",omitempty"`
+	Err     string             `json:",omitempty"`
+	Stage   FailureStage
+}
+
+// SessionRuleMatch Data matched by a rule in a Session
+type SessionRuleMatch struct {
+	RuleID string
+	Data   []byte
+	Offset int64
+	Before []byte `json:",omitempty"`
+	After  []byte `json:",omitempty"`
+}
+
+// pendingServer Server being processed, with a count in case the same server is read more than once
+type pendingServer struct {
+	server SessionServer
+	count  int
+}
+
+// newSessionServer Get the SessionServer for a server
+func newSessionServer(server genericenricher.Server) SessionServer {
+	return SessionServer{ConnectString: server.GetConnectString(), Type: server.Type()}
+}
+
+// newSessionMatch Get the SessionMatch for a match
+func newSessionMatch(match *Match) SessionMatch {
+	sessionMatch := SessionMatch{Matched: match.Matched, Stage: match.Stage, Dropped: match.DroppedMatches}
+	if match.Server != nil {
+		sessionMatch.Server = newSessionServer(match.Server)
+	}
+	if match.Err != nil {
+		sessionMatch.Err = match.Err.Error()
+	}
+	for _, m := range match.Matches {
+		sessionMatch.Matches = append(sessionMatch.Matches, SessionRuleMatch{RuleID: m.Rule.ID, Data: m.Data, Offset: m.Offset, Before: m.Before, After: m.After})
+	}
+	return sessionMatch
+}
+
+// Session Get the current state of the searcher.  This is safe to call while processing
+func (searcher *Searcher) Session() (*Session, error) {
+	session := &Session{
+		GetMatchedData:             searcher.GetMatchedData,
+		ExhaustiveMatching:         searcher.ExhaustiveMatching,
+		MaxMatchesPerRule:          searcher.MaxMatchesPerRule,
+		ReturnNotMatchedServers:    searcher.ReturnNotMatchedServers,
+		ReturnReaderErrors:         searcher.ReturnReaderErrors,
+		ServerDataLimit:            searcher.ServerDataLimit,
+		MatchContextBefore:         searcher.MatchContextBefore,
+		MatchContextAfter:          searcher.MatchContextAfter,
+		MaxMatchLength:             searcher.MaxMatchLength,
+		ServerReaderIterationStyle: searcher.ServerReaderIterationStyle,
+		ServerTimeout:              searcher.ServerTimeout,
+		Workers:                    searcher.Workers,
+	}
+	session.Rules = searcher.Rules()
+	if len(searcher.scope) > 0 {
+		session.Scope = searcher.scopeStrings()
+	}
+
+	// Stop starting and finishing reads so reader positions line up with the pending servers.
+	// A read in progress is not waited for, the state from before it is used instead
+	searcher.readLock.Lock()
+	defer searcher.readLock.Unlock()
+
+	for _, entry := range searcher.serverReaders {
+		sessionReader := SessionReader{Finished: entry.finished}
+		if statefulReader, ok := entry.reader.(StatefulServerReader); ok && !entry.finished {
+			state, err := entry.readState, entry.readStateErr
+			if !entry.reading {
+				state, err = statefulReader.State()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error getting server reader state: %v", err)
+			}
+			sessionReader.State = state
+		}
+		session.Readers = append(session.Readers, sessionReader)
+	}
+
+	searcher.stateLock.Lock()
+	defer searcher.stateLock.Unlock()
+
+	for _, server := range searcher.processed {
+		session.Processed = append(session.Processed, server)
+	}
+	for _, pending := range searcher.pending {
+		session.Pending = append(session.Pending, pending.server)
+	}
+	sortSessionServers(session.Processed)
+	sortSessionServers(session.Pending)
+	session.Matches = append(session.Matches, searcher.emitted...)
+
+	return session, nil
+}
+
+// SaveSession Save the current state of the searcher to a file.  This is safe to call while processing
+func (searcher *Searcher) SaveSession(filename string) error {
+	session, err := searcher.Session()
+	if err != nil {
+		return err
+	}
+	return session.Save(filename)
+}
+
+// CheckpointErr Get the error from the last time the Session was saved to CheckpointFile, if any
+func (searcher *Searcher) CheckpointErr() error {
+	searcher.stateLock.Lock()
+	defer searcher.stateLock.Unlock()
+	return searcher.checkpointErr
+}
+
+// checkpoint Save session to the CheckpointFile
+func (searcher *Searcher) checkpoint() {
+	err := searcher.SaveSession(searcher.CheckpointFile)
+	searcher.stateLock.Lock()
+	searcher.checkpointErr = err
+	searcher.stateLock.Unlock()
+}
+
+// Resume Restore a saved session and continue processing where it left off.
+// The same ServerReaders (and single servers) must be added to the searcher in the same order as when the session was saved.
+// Rules and options are replaced by the ones in the session.
+func (searcher *Searcher) Resume(ctx context.Context, session *Session) (matches chan *Match, err error) {
+	if len(session.Readers) != len(searcher.serverReaders) {
+		return nil, fmt.Errorf("session has %d server readers, searcher has %d", len(session.Readers), len(searcher.serverReaders))
+	}
+
+	// Rules are checked when the session is decoded
+	for _, rule := range session.Rules {
+		if rule == nil || rule.Regex == nil {
+			return nil, errors.New("session has a rule without a regex")
+		}
+	}
+
+	scope := []net.IPNet{}
+	for _, network := range session.Scope {
+		n, err := serverreaders.ParseIPNet(network)
+		if err != nil {
+			return nil, fmt.Errorf("session has invalid scope `%s`", network)
+		}
+		scope = append(scope, n)
+	}
+
+	// Recreate pending servers
+	pending := []genericenricher.Server{}
+	for _, sessionServer := range session.Pending {
+		server, err := genericenricher.GetServerWithType(sessionServer.ConnectString, sessionServer.Type)
+		if err != nil {
+			return nil, fmt.Errorf("error creating pending server %s: %v", sessionServer.ConnectString, err)
+		}
+		pending = append(pending, server)
+	}
+
+	// Restore reader positions
+	for i, sessionReader := range session.Readers {
+		entry := searcher.serverReaders[i]
+		if sessionReader.Finished || len(sessionReader.State) == 0 {
+			continue
+		}
+		statefulReader, ok := entry.reader.(StatefulServerReader)
+		if !ok {
+			return nil, errors.New("session has state for a server reader that does not implement StatefulServerReader")
+		}
+		if err := statefulReader.SetState(sessionReader.State); err != nil {
+			return nil, fmt.Errorf("error restoring server reader state: %v", err)
+		}
+	}
+
+	// Restore options
+	searcher.rules = append([]*Rule{}, session.Rules...)
+	searcher.GetMatchedData = session.GetMatchedData
+	searcher.ExhaustiveMatching = session.ExhaustiveMatching
+	searcher.MaxMatchesPerRule = session.MaxMatchesPerRule
+	searcher.ReturnNotMatchedServers = session.ReturnNotMatchedServers
+	searcher.ReturnReaderErrors = session.ReturnReaderErrors
+	searcher.ServerDataLimit = session.ServerDataLimit
+	searcher.MatchContextBefore = session.MatchContextBefore
+	searcher.MatchContextAfter = session.MatchContextAfter
+	searcher.MaxMatchLength = session.MaxMatchLength
+	searcher.ServerReaderIterationStyle = session.ServerReaderIterationStyle
+	searcher.ServerTimeout = session.ServerTimeout
+	searcher.Workers = session.Workers
+	if len(searcher.scope) == 0 {
+		// A scope added before resuming is kept, so it can't be widened by the session
+		searcher.scope = scope
+	}
+
+	// Restore progress
+	searcher.resetSession()
+	for i, sessionReader := range session.Readers {
+		searcher.serverReaders[i].finished = sessionReader.Finished
+	}
+	for _, server := range session.Processed {
+		searcher.processed[server.ConnectString] = server
+		searcher.skip[server.ConnectString] = true
+	}
+	for _, server := range pending {
+		searcher.addPending(server)
+	}
+	searcher.emitted = append(searcher.emitted, session.Matches...)
+
+	return searcher.process(ctx, pending), nil
+}
+
+// Save Write the session to a file as JSON.  The file is replaced atomically so a crash never leaves a partial session
+func (session *Session) Save(filename string) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// LoadSession Read a session saved with Session.Save or Searcher.SaveSession
+func LoadSession(filename string) (*Session, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("invalid session file: %v", err)
+	}
+	return session, nil
+}
+
+// resetSession Clear all progress, including which readers have finished
+func (searcher *Searcher) resetSession() {
+	searcher.readLock.Lock()
+	for _, entry := range searcher.serverReaders {
+		entry.finished = false
+	}
+	searcher.readLock.Unlock()
+
+	searcher.stateLock.Lock()
+	searcher.processed = map[string]SessionServer{}
+	searcher.skip = map[string]bool{}
+	searcher.pending = map[string]*pendingServer{}
+	searcher.emitted = nil
+	searcher.checkpointErr = nil
+	searcher.stateLock.Unlock()
+}
+
+// alreadyProcessed Check if the server was processed in the session that was resumed
+func (searcher *Searcher) alreadyProcessed(server genericenricher.Server) bool {
+	searcher.stateLock.Lock()
+	defer searcher.stateLock.Unlock()
+	return searcher.skip[server.GetConnectString()]
+}
+
+// addPending Mark server as read from a ServerReader but not yet processed
+func (searcher *Searcher) addPending(server genericenricher.Server) {
+	searcher.stateLock.Lock()
+	defer searcher.stateLock.Unlock()
+	sessionServer := newSessionServer(server)
+	if p, ok := searcher.pending[sessionServer.ConnectString]; ok {
+		p.count++
+		return
+	}
+	searcher.pending[sessionServer.ConnectString] = &pendingServer{server: sessionServer, count: 1}
+}
+
+// finishServer Mark server as processed.  Servers of a reader with state are not kept, as its state is after them
+func (searcher *Searcher) finishServer(server genericenricher.Server, fromReader bool, stateful bool) {
+	searcher.stateLock.Lock()
+	defer searcher.stateLock.Unlock()
+	sessionServer := newSessionServer(server)
+	if !stateful {
+		searcher.processed[sessionServer.ConnectString] = sessionServer
+	}
+	if !fromReader {
+		return
+	}
+	if p, ok := searcher.pending[sessionServer.ConnectString]; ok {
+		p.count--
+		if p.count <= 0 {
+			delete(searcher.pending, sessionServer.ConnectString)
+		}
+	}
+}
+
+// hasState Check if a reader is a StatefulServerReader that has state
+func hasState(reader ServerReader) bool {
+	statefulReader, ok := reader.(StatefulServerReader)
+	if !ok {
+		return false
+	}
+	state, err := statefulReader.State()
+	return err == nil && state != nil
+}
+
+// addEmitted Record match returned from Process, unless OmitSessionMatches is set
+func (searcher *Searcher) addEmitted(match *Match) {
+	if searcher.OmitSessionMatches {
+		return
+	}
+	sessionMatch := newSessionMatch(match)
+	searcher.stateLock.Lock()
+	defer searcher.stateLock.Unlock()
+	searcher.emitted = append(searcher.emitted, sessionMatch)
+}
+
+func sortSessionServers(servers []SessionServer) {
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].ConnectString < servers[j].ConnectString
+	})
+}
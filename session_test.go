@@ -0,0 +1,231 @@
+package serverpatdown
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vertoforce/genericenricher"
+	"github.com/vertoforce/genericenricher/enrichers"
+	"github.com/vertoforce/serverpatdown/serverreaders"
+)
+
+// newLocalScanner Create scanner over localhost on the ports of the test servers
+func newLocalScanner(servers []*httptest.Server) *serverreaders.Scanner {
+	scanner := serverreaders.NewScanner()
+	scanner.SetServerType(enrichers.HTTP)
+	scanner.AddIPNet(net.IPNet{IP: net.IP{127, 0, 0, 1}, Mask: net.IPv4Mask(255, 255, 255, 255)})
+	for _, server := range servers {
+		port, _ := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+		scanner.AddPort(port)
+	}
+	return scanner
+}
+
+func TestSessionResume(t *testing.T) {
+	servers := []*httptest.Server{}
+	for i := 0; i < 5; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "secret")
+		}))
+		defer server.Close()
+		servers = append(servers, server)
+	}
+
+	dir, err := ioutil.TempDir("", "serverpatdown")
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+	sessionFile := filepath.Join(dir, "session.json")
+
+	// Process until the first match, then stop
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.AddServerReader(newLocalScanner(servers))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	matches, err := searcher.Process(ctx)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	seen := map[string]int{}
+	for match := range matches {
+		seen[match.Server.GetConnectString()]++
+		cancel()
+	}
+	if len(seen) == 0 || len(seen) == len(servers) {
+		t.Errorf("Expected to stop part way through, matched %d servers", len(seen))
+	}
+	if err := searcher.SaveSession(sessionFile); err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+
+	// Resume with a new searcher
+	session, err := LoadSession(sessionFile)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	if len(session.Rules) != 1 || session.Rules[0].Regex.String() != "secret" {
+		t.Errorf("Did not save rules")
+	}
+	resumedPending := len(session.Pending)
+	searcher = NewSearcher()
+	searcher.AddServerReader(newLocalScanner(servers))
+	searcher.CheckpointFile = sessionFile
+	matches, err = searcher.Resume(context.Background(), session)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	for match := range matches {
+		seen[match.Server.GetConnectString()]++
+	}
+
+	// Check each server was matched exactly once
+	if len(seen) != len(servers) {
+		t.Errorf("Expected %d servers, got %d", len(servers), len(seen))
+	}
+	for server, count := range seen {
+		if count != 1 {
+			t.Errorf("Matched %s %d times", server, count)
+		}
+	}
+
+	// Check final checkpoint
+	session, err = LoadSession(sessionFile)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	// Servers of the scanner are not kept as processed as its state is after them, only the pending servers that were resumed
+	if !session.Readers[0].Finished || len(session.Pending) != 0 || len(session.Processed) != resumedPending || len(session.Matches) != len(servers) {
+		t.Errorf("Final checkpoint is not complete")
+	}
+}
+
+func TestSessionSize(t *testing.T) {
+	servers := []*httptest.Server{}
+	for i := 0; i < 3; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "secret")
+		}))
+		defer server.Close()
+		servers = append(servers, server)
+	}
+
+	for _, omitMatches := range []bool{false, true} {
+		// Servers from a reader without state, and from a scanner with state
+		searcher := NewSearcher()
+		searcher.AddSearchRule(regexp.MustCompile(`secret`))
+		searcher.OmitSessionMatches = omitMatches
+		fileReader := serverreaders.NewFileReader(strings.NewReader(servers[0].URL + "\n" + servers[1].URL + "\n"))
+		fileReader.SetServerType(enrichers.HTTP)
+		searcher.AddServerReader(fileReader)
+		searcher.AddServerReader(newLocalScanner(servers[2:]))
+		matches, err := searcher.Process(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range matches {
+		}
+
+		session, err := searcher.Session()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(session.Processed) != 2 || session.Processed[0].ConnectString > session.Processed[1].ConnectString {
+			t.Errorf("Expected only the servers of the file reader to be processed, got %v", session.Processed)
+		}
+		if omitMatches && len(session.Matches) != 0 {
+			t.Errorf("Matches should be left out of the session, got %d", len(session.Matches))
+		} else if !omitMatches && len(session.Matches) != len(servers) {
+			t.Errorf("Expected %d matches in the session, got %d", len(servers), len(session.Matches))
+		}
+	}
+}
+
+// slowStatefulReader StatefulServerReader returning one server, then moving on and waiting until it is closed
+type slowStatefulReader struct {
+	blockingServerReader
+	lock     sync.Mutex
+	server   genericenricher.Server
+	position int
+}
+
+func (r *slowStatefulReader) ReadServer() (genericenricher.Server, error) {
+	r.lock.Lock()
+	r.position++
+	position := r.position
+	r.lock.Unlock()
+	if position == 1 {
+		return r.server, nil
+	}
+	return r.blockingServerReader.ReadServer()
+}
+
+func (r *slowStatefulReader) State() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return []byte(strconv.Itoa(r.position)), nil
+}
+
+func (r *slowStatefulReader) SetState(state []byte) error { return nil }
+
+func TestSessionDuringSlowRead(t *testing.T) {
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	reader := &slowStatefulReader{blockingServerReader: blockingServerReader{closed: make(chan struct{})}, server: &fakeServer{data: []byte("secret")}}
+	searcher.AddServerReader(reader)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	matches, err := searcher.Process(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-matches
+
+	// The session is taken without waiting for the read, with the state from before it
+	sessions := make(chan *Session)
+	go func() {
+		session, err := searcher.Session()
+		if err != nil {
+			t.Error(err)
+		}
+		sessions <- session
+	}()
+	select {
+	case session := <-sessions:
+		if session != nil && string(session.Readers[0].State) != "1" {
+			t.Errorf("Expected the state from before the read in progress, got %s", session.Readers[0].State)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Session waited for a read in progress")
+	}
+	cancel()
+	for range matches {
+	}
+}
+
+func TestResumeReaderMismatch(t *testing.T) {
+	searcher := NewSearcher()
+	_, err := searcher.Resume(context.Background(), &Session{Readers: []SessionReader{{Finished: true}}})
+	if err == nil {
+		t.Errorf("Should not resume with a different number of readers")
+	}
+}
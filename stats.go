@@ -0,0 +1,197 @@
+package serverpatdown
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressReader ServerReader that can report how far through its servers it is, such as serverreaders.Scanner
+// and serverreaders.ShodanReader
+type ProgressReader interface {
+	ServerReader
+	// Progress Get the number of targets read and the total number of targets, or a total of 0 if it is not known.
+	// Targets are not always servers, a Scanner counts ip/port pairs whether or not the port is open
+	Progress() (read, total uint64)
+}
+
+// Stats Progress of Process, see Searcher.Stats
+type Stats struct {
+	Started   time.Time // When processing started
+	Finished  time.Time // When processing finished, zero while processing
+	Elapsed   time.Duration
+	Attempted int64 // Servers searched or being searched
+	Connected int64 // Servers connected to
+	Failed    int64 // Servers with an error, see Failures
+	Matched   int64 // Servers that matched a rule
+	BytesRead int64 // Data read from servers
+	Pending   int64 // Servers read from ServerReaders that are waiting to be searched or being searched
+	// Number of servers and ServerReaders with an error, by the stage it occurred at
+	Failures map[FailureStage]int64
+	// Number of servers each rule matched, by Rule.ID.  Unless GetMatchedData is set only the first rule to match a server is counted
+	RuleHits map[string]int64
+	// Progress of each ServerReader, in the order they were added
+	Readers []ReaderStats
+}
+
+// ReaderStats Progress of a ServerReader
+type ReaderStats struct {
+	Reader   ServerReader
+	Read     int64 // Servers read
+	Finished bool  // Returned EOF or an error
+	// Targets read and the total number of targets when the reader is a ProgressReader, Total is 0 if not known
+	Progress uint64
+	Total    uint64
+}
+
+// Remaining Get the number of targets left to read from the ServerReaders that are not finished.
+// ok is false if one of them can't report its total, in which case only the known targets are counted
+func (stats Stats) Remaining() (remaining uint64, ok bool) {
+	ok = true
+	for _, reader := range stats.Readers {
+		if reader.Finished {
+			continue
+		}
+		if reader.Total == 0 {
+			ok = false
+			continue
+		}
+		if reader.Total > reader.Progress {
+			remaining += reader.Total - reader.Progress
+		}
+	}
+	return remaining, ok
+}
+
+// Stats Get the progress of Process so far.  This can be called while processing
+func (searcher *Searcher) Stats() Stats {
+	searcher.statsLock.Lock()
+	stats := searcher.stats
+	stats.Failures = map[FailureStage]int64{}
+	for stage, count := range searcher.stats.Failures {
+		stats.Failures[stage] = count
+	}
+	stats.RuleHits = map[string]int64{}
+	for id, count := range searcher.stats.RuleHits {
+		stats.RuleHits[id] = count
+	}
+	for _, entry := range searcher.serverReaders {
+		stats.Readers = append(stats.Readers, entry.stats)
+	}
+	searcher.statsLock.Unlock()
+
+	searcher.stateLock.Lock()
+	for _, p := range searcher.pending {
+		stats.Pending += int64(p.count)
+	}
+	searcher.stateLock.Unlock()
+
+	if !stats.Finished.IsZero() {
+		stats.Elapsed = stats.Finished.Sub(stats.Started)
+	} else if !stats.Started.IsZero() {
+		stats.Elapsed = time.Since(stats.Started)
+	}
+
+	// Ask readers for their progress without holding the lock, as they could be busy reading
+	for i, reader := range stats.Readers {
+		if progressReader, ok := reader.Reader.(ProgressReader); ok {
+			stats.Readers[i].Progress, stats.Readers[i].Total = progressReader.Progress()
+		}
+	}
+	return stats
+}
+
+// resetStats Clear the stats and start timing processing
+func (searcher *Searcher) resetStats() {
+	searcher.readLock.Lock()
+	finished := make([]bool, len(searcher.serverReaders))
+	for i, entry := range searcher.serverReaders {
+		finished[i] = entry.finished
+	}
+	searcher.readLock.Unlock()
+
+	searcher.statsLock.Lock()
+	defer searcher.statsLock.Unlock()
+	searcher.stats = Stats{Started: time.Now(), Failures: map[FailureStage]int64{}, RuleHits: map[string]int64{}}
+	for i, entry := range searcher.serverReaders {
+		entry.stats = ReaderStats{Reader: entry.reader, Finished: finished[i]}
+	}
+}
+
+// updateStats Change the stats while holding statsLock
+func (searcher *Searcher) updateStats(update func(stats *Stats)) {
+	searcher.statsLock.Lock()
+	defer searcher.statsLock.Unlock()
+	update(&searcher.stats)
+}
+
+// addReaderStats Count a read from a ServerReader
+func (searcher *Searcher) addReaderStats(entry *serverReaderEntry, read bool, err error) {
+	searcher.statsLock.Lock()
+	defer searcher.statsLock.Unlock()
+	if read {
+		entry.stats.Read++
+	}
+	if err != nil {
+		entry.stats.Finished = true
+	}
+	if err != nil && err != io.EOF {
+		searcher.stats.Failures[ReaderFailure]++
+	}
+}
+
+// addMatchStats Count the result of searching a server
+func (searcher *Searcher) addMatchStats(match *Match) {
+	searcher.updateStats(func(stats *Stats) {
+		if match.Err != nil {
+			stats.Failed++
+			stats.Failures[match.Stage]++
+		}
+		if match.Matched {
+			stats.Matched++
+		}
+		for _, rule := range match.Rules {
+			stats.RuleHits[rule.ID]++
+		}
+	})
+}
+
+// reportProgress Call ProgressFunc every ProgressInterval until stop is closed
+func (searcher *Searcher) reportProgress(stop chan struct{}) {
+	if searcher.ProgressFunc == nil || searcher.ProgressInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(searcher.ProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			searcher.ProgressFunc(searcher.Stats())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// statsReader Counts the bytes read from a server, adding them to the stats of the searcher
+type statsReader struct {
+	read     int64 // Accessed atomically, as the matcher can still be reading when the search finishes.  First to be 64-bit aligned
+	searcher *Searcher
+	io.ReadCloser
+}
+
+func (r *statsReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&r.read, int64(n))
+		r.searcher.updateStats(func(stats *Stats) {
+			stats.BytesRead += int64(n)
+		})
+	}
+	return n, err
+}
+
+// BytesRead Get the bytes read so far
+func (r *statsReader) BytesRead() int64 {
+	return atomic.LoadInt64(&r.read)
+}
@@ -0,0 +1,121 @@
+package serverpatdown
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vertoforce/genericenricher"
+)
+
+func TestStats(t *testing.T) {
+	searcher := NewSearcher()
+	searcher.Workers = 4
+	searcher.AddRule(&Rule{ID: "secret", Regex: regexp.MustCompile(`secret`)})
+	searcher.AddRule(&Rule{ID: "password", Regex: regexp.MustCompile(`password`)})
+	searcher.AddScope(net.IPNet{IP: net.IP{127, 0, 0, 0}, Mask: net.CIDRMask(8, 32)})
+	searcher.AddServer(&fakeServer{data: []byte("a secret")})
+	searcher.AddServer(&fakeServer{data: []byte("nothing here")})
+	searcher.AddServer(&fakeServer{connectErr: errors.New("connection refused")})
+	searcher.AddServer(&fakeServer{ip: net.IP{10, 0, 0, 1}, data: []byte("a secret")})
+	searcher.AddServerReader(&serverListReader{servers: []genericenricher.Server{
+		&fakeServer{data: []byte("a password")},
+		&fakeServer{data: []byte("partial data"), readErr: errors.New("connection reset")},
+	}})
+	searcher.AddServerReader(&errorServerReader{err: errors.New("quota exhausted")})
+
+	lock := sync.Mutex{}
+	reports := []Stats{}
+	searcher.ProgressInterval = time.Millisecond
+	searcher.ProgressFunc = func(stats Stats) {
+		lock.Lock()
+		reports = append(reports, stats)
+		lock.Unlock()
+	}
+
+	matches, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range matches {
+	}
+
+	stats := searcher.Stats()
+	if stats.Attempted != 6 || stats.Connected != 4 || stats.Failed != 3 || stats.Matched != 2 {
+		t.Errorf("Wrong counts: %+v", stats)
+	}
+	if stats.BytesRead != int64(len("a secret")+len("nothing here")+len("a password")+len("partial data")) {
+		t.Errorf("Wrong bytes read: %d", stats.BytesRead)
+	}
+	failures := map[FailureStage]int64{ConnectFailure: 1, ReadFailure: 1, ScopeFailure: 1, ReaderFailure: 1}
+	for stage, count := range failures {
+		if stats.Failures[stage] != count {
+			t.Errorf("Expected %d %s failures, got %d", count, stage, stats.Failures[stage])
+		}
+	}
+	if stats.RuleHits["secret"] != 1 || stats.RuleHits["password"] != 1 {
+		t.Errorf("Wrong rule hits: %v", stats.RuleHits)
+	}
+	if len(stats.Readers) != 2 || stats.Readers[0].Read != 2 || !stats.Readers[0].Finished ||
+		stats.Readers[1].Read != 0 || !stats.Readers[1].Finished {
+		t.Errorf("Wrong reader stats: %+v", stats.Readers)
+	}
+	if stats.Pending != 0 {
+		t.Errorf("Expected no pending servers, got %d", stats.Pending)
+	}
+	if stats.Finished.IsZero() || stats.Elapsed <= 0 {
+		t.Errorf("Processing should be finished")
+	}
+
+	// Progress is reported once more at the end
+	lock.Lock()
+	defer lock.Unlock()
+	if len(reports) == 0 || reports[len(reports)-1].Matched != 2 || reports[len(reports)-1].Finished.IsZero() {
+		t.Errorf("Did not report final progress")
+	}
+}
+
+func TestStatsReaderProgress(t *testing.T) {
+	servers := []*httptest.Server{}
+	for i := 0; i < 3; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+		servers = append(servers, server)
+	}
+	scanner := newLocalScanner(servers)
+
+	searcher := NewSearcher()
+	searcher.AddSearchRule(regexp.MustCompile(`secret`))
+	searcher.AddServerReader(scanner)
+	searcher.AddServerReader(&serverListReader{})
+
+	// Before processing the total is known, but not the progress of other readers
+	stats := searcher.Stats()
+	if stats.Readers[0].Progress != 0 || stats.Readers[0].Total != 3 {
+		t.Errorf("Wrong scanner progress: %+v", stats.Readers[0])
+	}
+	if remaining, ok := stats.Remaining(); remaining != 3 || ok {
+		t.Errorf("Expected 3 remaining and unknown, got %d %v", remaining, ok)
+	}
+
+	matches, err := searcher.Process(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range matches {
+	}
+
+	stats = searcher.Stats()
+	if stats.Readers[0].Progress != 3 || stats.Readers[0].Total != 3 || stats.Readers[0].Read != 3 {
+		t.Errorf("Wrong scanner progress: %+v", stats.Readers[0])
+	}
+	if remaining, ok := stats.Remaining(); remaining != 0 || !ok {
+		t.Errorf("Expected nothing remaining, got %d %v", remaining, ok)
+	}
+}